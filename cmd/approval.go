@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// Modes of approval of a remediation.
+const (
+	approvalTTY  = "tty"
+	approvalHTTP = "http"
+)
+
+// Decisions applied when no approver answered before the deadline.
+const (
+	approvalDefaultRollback = "rollback"
+	approvalDefaultAbort    = "abort"
+)
+
+// notApprovedError is returned when a remediation was rejected, or not
+// approved before the deadline with the abort default.
+type notApprovedError struct {
+	action string
+	reason string
+}
+
+func (e *notApprovedError) Error() string {
+	return fmt.Sprintf("%s not approved: %s", e.action, e.reason)
+}
+
+func isNotApproved(err error) bool {
+	var notApproved *notApprovedError
+	return errors.As(err, &notApproved)
+}
+
+// approvalDecision is the answer of an approver.
+type approvalDecision struct {
+	approved bool
+	approver string
+	via      string
+	at       time.Time
+}
+
+func validateApproval(mode, defaultDecision string) error {
+	switch mode {
+	case "", approvalTTY, approvalHTTP:
+	default:
+		return fmt.Errorf("unknown approval mode %q, expected one of %s, %s", mode, approvalTTY, approvalHTTP)
+	}
+
+	switch defaultDecision {
+	case approvalDefaultRollback, approvalDefaultAbort:
+	default:
+		return fmt.Errorf("unknown approval default %q, expected one of %s, %s", defaultDecision, approvalDefaultRollback, approvalDefaultAbort)
+	}
+
+	return nil
+}
+
+// checkApproval validates the approval options of the engine, the terminal
+// approval requires the standard input to be a terminal and the http approval
+// a token.
+func (e *engine) checkApproval() error {
+	if e.approval == "" {
+		return nil
+	}
+	if err := validateApproval(e.approval, e.approvalDefault); err != nil {
+		return err
+	}
+	if e.approvalTimeout <= 0 {
+		return fmt.Errorf("approval timeout must be greater than 0, got %s", e.approvalTimeout)
+	}
+
+	if e.approval == approvalHTTP && e.approvalToken == "" {
+		return fmt.Errorf("the %s approval requires --approval-token or $HELM_MONITOR_APPROVAL_TOKEN", approvalHTTP)
+	}
+
+	if f, ok := e.in.(*os.File); ok && e.approval == approvalTTY {
+		if stat, err := f.Stat(); err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+			return fmt.Errorf("the %s approval requires a terminal", approvalTTY)
+		}
+	}
+
+	return nil
+}
+
+// approve waits for a human to approve the remediation, until the approval
+// timeout after which the default decision applies. The approver and the
+// time of the decision are printed.
+func (e *engine) approve(interrupted <-chan struct{}) error {
+	requested := time.Now()
+	ctx, cancel := context.WithDeadline(context.Background(), requested.Add(e.approvalTimeout))
+	defer cancel()
+
+	decisions := make(chan *approvalDecision, 1)
+
+	switch e.approval {
+	case approvalTTY:
+		fmt.Fprintf(e.out, "Approve the %s of %s? No answer before %s means %s [y/N]: ",
+			e.action, e.name, requested.Add(e.approvalTimeout).Format(time.RFC3339), e.approvalDefault)
+		go readApproval(e.in, decisions)
+
+	case approvalHTTP:
+		listener, err := net.Listen("tcp", e.approvalAddress)
+		if err != nil {
+			return fmt.Errorf("could not listen for approvals: %s", err)
+		}
+		server := &http.Server{Handler: approvalHandler(e.approvalToken, decisions)}
+		go server.Serve(listener)
+		defer server.Close()
+
+		fmt.Fprintf(e.out, "Waiting for the approval of the %s of %s until %s, POST to http://%s/approve or /reject with an approver\n",
+			e.action, e.name, requested.Add(e.approvalTimeout).Format(time.RFC3339), listener.Addr())
+	}
+
+	select {
+	case d := <-decisions:
+		verb := "approved"
+		if !d.approved {
+			verb = "rejected"
+		}
+		fmt.Fprintf(e.out, "The %s was %s by %s via %s at %s (requested at %s)\n",
+			e.action, verb, d.approver, d.via, d.at.Format(time.RFC3339), requested.Format(time.RFC3339))
+		if !d.approved {
+			return &notApprovedError{action: e.action.String(), reason: fmt.Sprintf("rejected by %s", d.approver)}
+		}
+		return nil
+
+	case <-ctx.Done():
+		fmt.Fprintf(e.out, "No decision before %s, applying the default: %s\n", time.Now().Format(time.RFC3339), e.approvalDefault)
+		if e.approvalDefault == approvalDefaultRollback {
+			return nil
+		}
+		return &notApprovedError{action: e.action.String(), reason: fmt.Sprintf("no decision within %s", e.approvalTimeout)}
+
+	case <-interrupted:
+		fmt.Fprintf(e.out, "Interrupted while waiting for approval\n")
+		return &notApprovedError{action: e.action.String(), reason: "interrupted while waiting for approval"}
+	}
+}
+
+// readApproval reads the answer of the approver on the terminal, the approver
+// is the current user.
+func readApproval(in io.Reader, decisions chan<- *approvalDecision) {
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && answer == "" {
+		return
+	}
+
+	approver := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		approver = u.Username
+	}
+	if approver == "" {
+		approver = "unknown"
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	decisions <- &approvalDecision{
+		approved: answer == "y" || answer == "yes",
+		approver: approver,
+		via:      approvalTTY,
+		at:       time.Now(),
+	}
+}
+
+// approvalHandler serves the /approve and /reject endpoints, which require
+// the token as a bearer token and the name of the approver in the approver
+// form value. Only the first decision is taken into account.
+func approvalHandler(token string, decisions chan<- *approvalDecision) http.Handler {
+	decide := func(approved bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			approver := r.FormValue("approver")
+			if approver == "" {
+				http.Error(w, "approver is required", http.StatusBadRequest)
+				return
+			}
+
+			d := &approvalDecision{
+				approved: approved,
+				approver: approver,
+				via:      fmt.Sprintf("http from %s", r.RemoteAddr),
+				at:       time.Now(),
+			}
+			select {
+			case decisions <- d:
+				fmt.Fprintf(w, "ok\n")
+			default:
+				http.Error(w, "already decided", http.StatusConflict)
+			}
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/approve", decide(true))
+	mux.Handle("/reject", decide(false))
+	return mux
+}
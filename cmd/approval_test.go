@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEngineApproval(t *testing.T) {
+	for _, test := range []struct {
+		name              string
+		answer            string
+		approvalDefault   string
+		expectedRollbacks int
+		expectedStatus    int
+	}{
+		{
+			name:              "it should rollback once approved",
+			answer:            "y\n",
+			approvalDefault:   approvalDefaultAbort,
+			expectedRollbacks: 1,
+		},
+		{
+			name:            "it should not rollback once rejected",
+			answer:          "n\n",
+			approvalDefault: approvalDefaultRollback,
+			expectedStatus:  exitNotApproved,
+		},
+		{
+			name:            "it should abort without a decision with the abort default",
+			approvalDefault: approvalDefaultAbort,
+			expectedStatus:  exitNotApproved,
+		},
+		{
+			name:              "it should rollback without a decision with the rollback default",
+			approvalDefault:   approvalDefaultRollback,
+			expectedRollbacks: 1,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			client := newFakeHelmClient("my-release")
+			e := newTestEngine(client, combinationRule{kind: ruleAny}, onDatasourceErrorAbort,
+				&fakeProvider{results: []*Result{{Count: 2}}})
+			e.approval = approvalTTY
+			e.approvalTimeout = 20 * time.Millisecond
+			e.approvalDefault = test.approvalDefault
+			e.in = strings.NewReader(test.answer)
+
+			err := e.run(context.Background())
+
+			status := 0
+			if err != nil {
+				status = exitStatus(err)
+			}
+
+			// the revision is only marked as failed once the rollback is applied
+			failed, recordsErr := (&releaseRecords{kube: e.kube, namespace: e.tillerNamespace}).revisions(e.name, recordFailed)
+			if recordsErr != nil {
+				t.Fatal(recordsErr)
+			}
+
+			if client.rollbacks != test.expectedRollbacks || status != test.expectedStatus || len(failed) != test.expectedRollbacks {
+				t.Errorf(
+					"\ngiven %q\nexpected: %d rollback(s), exit status %d\ngot: %d rollback(s), exit status %d (%v), failed revisions %v\n",
+					test.answer,
+					test.expectedRollbacks,
+					test.expectedStatus,
+					client.rollbacks,
+					status,
+					err,
+					failed,
+				)
+			}
+		})
+	}
+}
+
+func TestApprovalHandler(t *testing.T) {
+	for _, test := range []struct {
+		name             string
+		method           string
+		path             string
+		token            string
+		approver         string
+		expectedStatus   int
+		expectedDecision string
+	}{
+		{
+			name:           "it should only accept POST requests",
+			method:         http.MethodGet,
+			path:           "/approve",
+			token:          "secret",
+			approver:       "alice",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:           "it should require the token",
+			method:         http.MethodPost,
+			path:           "/approve",
+			token:          "wrong",
+			approver:       "alice",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "it should require the approver",
+			method:         http.MethodPost,
+			path:           "/approve",
+			token:          "secret",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:             "it should approve",
+			method:           http.MethodPost,
+			path:             "/approve",
+			token:            "secret",
+			approver:         "alice",
+			expectedStatus:   http.StatusOK,
+			expectedDecision: "approved",
+		},
+		{
+			name:             "it should reject",
+			method:           http.MethodPost,
+			path:             "/reject",
+			token:            "secret",
+			approver:         "alice",
+			expectedStatus:   http.StatusOK,
+			expectedDecision: "rejected",
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			decisions := make(chan *approvalDecision, 1)
+
+			form := url.Values{}
+			if test.approver != "" {
+				form.Set("approver", test.approver)
+			}
+			req := httptest.NewRequest(test.method, test.path, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Authorization", "Bearer "+test.token)
+
+			res := httptest.NewRecorder()
+			approvalHandler("secret", decisions).ServeHTTP(res, req)
+
+			decision := ""
+			select {
+			case d := <-decisions:
+				decision = "rejected"
+				if d.approved {
+					decision = "approved"
+				}
+			default:
+			}
+
+			if res.Code != test.expectedStatus || decision != test.expectedDecision {
+				t.Errorf(
+					"\nexpected: status %d, decision %q\ngot: status %d, decision %q\n",
+					test.expectedStatus,
+					test.expectedDecision,
+					res.Code,
+					decision,
+				)
+			}
+		})
+	}
+}
+
+func TestCheckApproval(t *testing.T) {
+	e := newTestEngine(newFakeHelmClient("my-release"), combinationRule{kind: ruleAny}, onDatasourceErrorAbort)
+	e.approval = approvalHTTP
+	e.approvalDefault = approvalDefaultAbort
+	e.approvalTimeout = time.Minute
+
+	if err := e.checkApproval(); err == nil {
+		t.Errorf("expected the http approval to be rejected without token")
+	}
+
+	e.approvalToken = "secret"
+	if err := e.checkApproval(); err != nil {
+		t.Errorf("expected the http approval to be accepted with a token, got %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// condition describes when the value returned by a check is considered as
+// failing, for example "> 0", "<= 100", "in 10..20", "not in 10..20" or
+// "< -20%". When the threshold is a percentage, the condition applies to the
+// change of the value relative to the value at the start of the monitoring.
+// A count condition applies to the result count instead of the value.
+type condition struct {
+	op       string
+	value    float64
+	min      float64
+	max      float64
+	relative bool
+	count    bool
+}
+
+var conditionOperators = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// parseCondition parses a condition made of an operator followed by a number,
+// or a range of numbers separated by .. for the in and not in operators.
+func parseCondition(s string) (*condition, error) {
+	s = strings.TrimSpace(s)
+
+	for _, op := range []string{"not in", "in"} {
+		if !strings.HasPrefix(s, op+" ") {
+			continue
+		}
+
+		bounds := strings.Split(strings.TrimSpace(strings.TrimPrefix(s, op)), "..")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("invalid condition %q, expected a range like %s 10..20", s, op)
+		}
+
+		min, minRelative, err := parseConditionValue(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition %q: %s", s, err)
+		}
+		max, maxRelative, err := parseConditionValue(bounds[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition %q: %s", s, err)
+		}
+		if minRelative != maxRelative {
+			return nil, fmt.Errorf("invalid condition %q, both bounds must be either values or percentages", s)
+		}
+		if min > max {
+			return nil, fmt.Errorf("invalid condition %q, the lower bound is greater than the upper bound", s)
+		}
+
+		return &condition{op: op, min: min, max: max, relative: minRelative}, nil
+	}
+
+	for _, op := range conditionOperators {
+		if !strings.HasPrefix(s, op) {
+			continue
+		}
+
+		value, relative, err := parseConditionValue(strings.TrimPrefix(s, op))
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition %q: %s", s, err)
+		}
+
+		return &condition{op: op, value: value, relative: relative}, nil
+	}
+
+	return nil, fmt.Errorf("invalid condition %q, expected an operator (%s, in, not in) followed by a value", s, strings.Join(conditionOperators, ", "))
+}
+
+// parseConditionValue parses a number, or a percentage if suffixed by %.
+func parseConditionValue(s string) (value float64, relative bool, err error) {
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "%") {
+		relative = true
+		s = strings.TrimSpace(strings.TrimSuffix(s, "%"))
+	}
+
+	value, err = strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("%q is not a number", s)
+	}
+
+	return value, relative, nil
+}
+
+// newCountCondition returns the condition failing when a result count exceeds
+// the expected result count.
+func newCountCondition(expectedResultCount int64) *condition {
+	return &condition{op: ">", value: float64(expectedResultCount), count: true}
+}
+
+// failed returns true if the value matches the condition. For relative
+// conditions, the value is first converted into a percentage of change from
+// the baseline.
+func (c *condition) failed(value, baseline float64) bool {
+	if c.relative {
+		value = percentChange(value, baseline)
+	}
+
+	switch c.op {
+	case ">":
+		return value > c.value
+	case ">=":
+		return value >= c.value
+	case "<":
+		return value < c.value
+	case "<=":
+		return value <= c.value
+	case "==":
+		return value == c.value
+	case "!=":
+		return value != c.value
+	case "in":
+		return value >= c.min && value <= c.max
+	case "not in":
+		return value < c.min || value > c.max
+	}
+
+	return false
+}
+
+// percentChange returns the change from the baseline to the value, in
+// percent. Any increase from a zero baseline is an infinite change.
+func percentChange(value, baseline float64) float64 {
+	if baseline == 0 {
+		if value == 0 {
+			return 0
+		}
+		return math.Copysign(math.Inf(1), value)
+	}
+
+	return (value - baseline) / math.Abs(baseline) * 100
+}
+
+func (c *condition) String() string {
+	format := func(v float64) string {
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if c.relative {
+			s += "%"
+		}
+		return s
+	}
+
+	if c.op == "in" || c.op == "not in" {
+		return fmt.Sprintf("%s %s..%s", c.op, format(c.min), format(c.max))
+	}
+
+	return fmt.Sprintf("%s %s", c.op, format(c.value))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+type conditionInput struct {
+	condition string
+	value     float64
+	baseline  float64
+}
+
+func TestConditionFailed(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		input    conditionInput
+		expected bool
+	}{
+		{
+			name:     "it should fail when the value is greater than the threshold",
+			input:    conditionInput{condition: "> 0", value: 1},
+			expected: true,
+		},
+		{
+			name:     "it should not fail when the value is equal to the threshold",
+			input:    conditionInput{condition: "> 1", value: 1},
+			expected: false,
+		},
+		{
+			name:     "it should fail when the value drops below a floor",
+			input:    conditionInput{condition: "< 10", value: 9.5},
+			expected: true,
+		},
+		{
+			name:     "it should compare values with >=",
+			input:    conditionInput{condition: ">= 5", value: 5},
+			expected: true,
+		},
+		{
+			name:     "it should compare values with ==",
+			input:    conditionInput{condition: "==0", value: 0},
+			expected: true,
+		},
+		{
+			name:     "it should compare values with !=",
+			input:    conditionInput{condition: "!= 0", value: 0},
+			expected: false,
+		},
+		{
+			name:     "it should include the bounds of a range",
+			input:    conditionInput{condition: "in 10..20", value: 20},
+			expected: true,
+		},
+		{
+			name:     "it should fail when the value is outside of a range",
+			input:    conditionInput{condition: "not in 10..20", value: 21},
+			expected: true,
+		},
+		{
+			name:     "it should fail when the value dropped more than a percentage",
+			input:    conditionInput{condition: "< -20%", value: 70, baseline: 100},
+			expected: true,
+		},
+		{
+			name:     "it should not fail when the value dropped less than a percentage",
+			input:    conditionInput{condition: "< -20%", value: 90, baseline: 100},
+			expected: false,
+		},
+		{
+			name:     "it should consider any increase from zero as an infinite change",
+			input:    conditionInput{condition: "> 50%", value: 1, baseline: 0},
+			expected: true,
+		},
+		{
+			name:     "it should apply ranges of percentages",
+			input:    conditionInput{condition: "not in -10%..10%", value: 105, baseline: 100},
+			expected: false,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			c, err := parseCondition(test.input.condition)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			output := c.failed(test.input.value, test.input.baseline)
+			if output != test.expected {
+				t.Errorf("\ngiven %+v\nexpected: %v\ngot: %v\n", test.input, test.expected, output)
+			}
+		})
+	}
+}
+
+func TestParseConditionErrors(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"0",
+		"> abc",
+		"in 10",
+		"in 20..10",
+		"in 10..20%",
+	} {
+		t.Run(fmt.Sprintf("it should reject %q", input), func(t *testing.T) {
+			if c, err := parseCondition(input); err == nil {
+				t.Errorf("\ngiven %q\nexpected an error\ngot: %v\n", input, c)
+			}
+		})
+	}
+}
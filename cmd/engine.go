@@ -0,0 +1,919 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// engine polls a set of checks at a given interval and remediates the release,
+// by rolling it back by default, if the combination of their results is
+// considered as a failure. It is shared by all the monitor subcommands.
+type engine struct {
+	name   string
+	out    io.Writer
+	client helm.Interface
+	checks []*check
+	rule   combinationRule
+
+	// revision is the revision of the release pinned at the start of the
+	// session, monitoring stops if the release moves to another revision.
+	// Rollbacks target rollbackTo if set, the last known-good revision
+	// preceding it otherwise
+	revision        int32
+	rollbackTo      int32
+	tillerNamespace string
+
+	// safeguards preventing a rollback: at most maxRollbacks automatic
+	// rollbacks within rollbackWindow, disabled if 0, and no rollback across
+	// a major version of the chart unless allowMajorRollback
+	maxRollbacks       int
+	rollbackWindow     time.Duration
+	allowMajorRollback bool
+
+	// verifyDuration is the duration of the verification following a
+	// rollback, disabled if 0, evaluating verifyChecks or the checks
+	verifyDuration time.Duration
+	verifyChecks   []*check
+
+	// remediationAction is the action applied once a failure is detected,
+	// action is the remediation created from it at the start of the session
+	remediationAction string
+	remediationSet    []string
+	webhookURL        string
+	action            remediation
+
+	// approval makes the remediation wait for a human decision, on the
+	// terminal read from in or on an HTTP endpoint, for at most
+	// approvalTimeout after which approvalDefault applies
+	approval        string
+	approvalTimeout time.Duration
+	approvalDefault string
+	approvalAddress string
+	approvalToken   string
+	in              io.Reader
+
+	// failures are not acted on while the monitoring is paused by the pause
+	// annotation or an active silence of pauseAlertmanager matching the
+	// release on pauseLabel, pausedBy is the reason of the current pause
+	pauseAlertmanager string
+	pauseHTTP         httpOptions
+	pauseClient       *http.Client
+	pauseLabel        string
+	pausedBy          string
+
+	// onLocked is the behavior when the release is locked by another
+	// monitor: fail, wait or take-over a stale lock, locking is disabled if
+	// empty. The lock is stale once not renewed for lockDuration
+	onLocked     string
+	lockDuration time.Duration
+
+	interval time.Duration
+	timeout  time.Duration
+
+	// readyTimeout bounds the wait for the release to be deployed and its
+	// workloads to be ready before monitoring, disabled if 0. The evaluations
+	// of the warmUp period following it are recorded but never acted on.
+	readyTimeout     time.Duration
+	warmUp           time.Duration
+	rollbackOnFailed bool
+
+	// kube is created once on first use by kubeClient, which is also called
+	// by the reads of the pause annotation outliving their interval
+	kube        kubernetes.Interface
+	kubeErr     error
+	kubeOnce    sync.Once
+	kubeContext string
+
+	// errorBudget is the number of evaluations failing because of a
+	// datasource error tolerated during the session, once exhausted the
+	// onDatasourceError policy applies: keep watching, abort or rollback
+	errorBudget       int
+	onDatasourceError string
+	datasourceErrors  int
+
+	disableHooks    bool
+	dryRun          bool
+	force           bool
+	rollbackTimeout int64
+	wait            bool
+}
+
+// check is a provider query evaluated by the engine at each interval, it
+// fails if the result count matches its condition and is breached once its
+// failure policy is met.
+type check struct {
+	name      string
+	provider  Provider
+	condition *condition
+	policy    failurePolicy
+
+	// absent makes the check fail when the provider returned no data for at
+	// least absentFor, absentSince is the time of the first evaluation of the
+	// current sequence of evaluations without data
+	absent      bool
+	absentFor   time.Duration
+	absentSince time.Time
+
+	// volume is an optional query measuring the traffic, evaluations are
+	// skipped as long as the volume is lower than minVolume, sufficientVolume
+	// is true once the minimum volume was reached
+	volume           Provider
+	minVolume        int64
+	sufficientVolume bool
+
+	// retry is applied to every query, requestErrors counts every failed
+	// request and evaluationErrors the evaluations which failed after retries
+	retry            retryPolicy
+	requestErrors    int
+	evaluationErrors int
+	lastError        error
+
+	// baseline is the first value returned by the provider, used by relative
+	// conditions
+	baseline *float64
+
+	// evaluations before warmUpUntil are ignored by the failure policy, judged
+	// is true once an evaluation succeeded after the warm-up with a sufficient
+	// volume
+	warmUpUntil time.Time
+	judged      bool
+
+	// history of the last evaluations, oldest first
+	history []*evaluation
+}
+
+// evaluation is the outcome of a check at a given interval.
+type evaluation struct {
+	check  *check
+	time   time.Time
+	result *Result
+	value  float64
+	err    error
+	failed bool
+
+	// absent is true if the check failed because of the absence of data
+	absent bool
+
+	// insufficient is true if the traffic volume was lower than the minimum
+	// volume, the evaluation neither passed nor failed
+	volume       float64
+	insufficient bool
+
+	// warmUp is true if the evaluation happened during the warm-up period
+	warmUp bool
+
+	// failingSince is the time of the first evaluation of the current
+	// sequence of failing evaluations
+	failingSince time.Time
+
+	// breached is true if the failure policy of the check is met
+	breached bool
+}
+
+// newEngine returns an engine configured from the persistent monitor flags.
+func newEngine(name string, out io.Writer, client helm.Interface, checks ...*check) *engine {
+	return &engine{
+		name:               name,
+		out:                out,
+		client:             client,
+		checks:             checks,
+		rule:               combinationRule{kind: ruleAny},
+		interval:           time.Second * time.Duration(monitor.interval),
+		timeout:            time.Second * time.Duration(monitor.timeout),
+		readyTimeout:       time.Second * time.Duration(monitor.readyTimeout),
+		warmUp:             time.Second * time.Duration(monitor.warmUp),
+		rollbackOnFailed:   monitor.rollbackOnFailed,
+		kubeContext:        monitor.kubeContext,
+		errorBudget:        monitor.errorBudget,
+		onDatasourceError:  monitor.onDatasourceError,
+		disableHooks:       monitor.disableHooks,
+		dryRun:             monitor.dryRun,
+		force:              monitor.force,
+		rollbackTimeout:    monitor.rollbackTimeout,
+		rollbackTo:         monitor.rollbackTo,
+		tillerNamespace:    settings.TillerNamespace,
+		maxRollbacks:       monitor.maxRollbacks,
+		rollbackWindow:     time.Second * time.Duration(monitor.rollbackWindow),
+		allowMajorRollback: monitor.allowMajorRollback,
+		verifyDuration:     time.Second * time.Duration(monitor.verify),
+		remediationAction:  monitor.remediation,
+		remediationSet:     monitor.remediationSet,
+		webhookURL:         monitor.webhookURL,
+		approval:           monitor.approval,
+		approvalTimeout:    time.Second * time.Duration(monitor.approvalTimeout),
+		approvalDefault:    monitor.approvalDefault,
+		approvalAddress:    monitor.approvalAddress,
+		approvalToken:      monitor.approvalToken,
+		in:                 os.Stdin,
+		pauseAlertmanager:  monitor.pauseAlertmanager,
+		pauseHTTP:          monitor.pauseHTTP,
+		pauseLabel:         monitor.pauseLabel,
+		onLocked:           monitor.onLocked,
+		lockDuration:       time.Second * time.Duration(monitor.lockDuration),
+		wait:               monitor.wait,
+	}
+}
+
+// newCheck returns a check using the condition, or the expected result count,
+// and the failure policy from the persistent monitor flags.
+func newCheck(name string, provider Provider) (*check, error) {
+	cond := newCountCondition(monitor.expectedResultCount)
+	if monitor.condition != "" {
+		var err error
+		cond, err = parseCondition(monitor.condition)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &check{
+		name:      name,
+		provider:  provider,
+		condition: cond,
+		absent:    monitor.absent,
+		absentFor: time.Second * time.Duration(monitor.absentFor),
+		minVolume: monitor.minVolume,
+		retry: retryPolicy{
+			retries: monitor.retries,
+			backoff: monitor.retryBackoff,
+		},
+		policy: failurePolicy{
+			consecutiveFailures: monitor.consecutiveFailures,
+			windowFailures:      monitor.windowFailures,
+			windowSize:          monitor.windowSize,
+			failureDuration:     time.Second * time.Duration(monitor.failureDuration),
+		},
+	}, nil
+}
+
+// run monitors the release until a failure is detected, the timeout is reached
+// or the context is cancelled. SIGINT and SIGTERM stop the monitoring
+// immediately but a rollback in progress is only abandoned on a second signal.
+func (e *engine) run(ctx context.Context) error {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(quit)
+
+	return e.session(ctx, quit)
+}
+
+// session runs the monitoring session, interrupted by the signals received on
+// quit.
+func (e *engine) session(parent context.Context, quit <-chan os.Signal) error {
+	for _, c := range e.checks {
+		if err := c.policy.validate(); err != nil {
+			return fmt.Errorf("%s: %s", c.name, err)
+		}
+		if err := c.retry.validate(); err != nil {
+			return fmt.Errorf("%s: %s", c.name, err)
+		}
+	}
+
+	if err := validateOnDatasourceError(e.onDatasourceError); err != nil {
+		return err
+	}
+
+	if e.onLocked != "" {
+		if err := validateOnLocked(e.onLocked); err != nil {
+			return err
+		}
+		if e.lockDuration <= 0 {
+			return fmt.Errorf("lock duration must be greater than 0, got %s", e.lockDuration)
+		}
+	}
+
+	action, err := e.newRemediation()
+	if err != nil {
+		return err
+	}
+	e.action = action
+
+	if err := e.checkApproval(); err != nil {
+		return err
+	}
+
+	if e.pauseAlertmanager != "" {
+		e.pauseClient, err = e.pauseHTTP.client(e.interval)
+		if err != nil {
+			return fmt.Errorf("pause alertmanager: %s", err)
+		}
+	}
+
+	base, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	// the first signal cancels the session, the second one abandons the
+	// rollback in progress, if any
+	interrupted := make(chan struct{})
+	abandoned := make(chan struct{})
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		for _, ch := range []chan struct{}{interrupted, abandoned} {
+			select {
+			case sig := <-quit:
+				debug("Received %s", sig)
+				close(ch)
+				cancel()
+			case <-stopped:
+				return
+			}
+		}
+	}()
+
+	if e.onLocked != "" {
+		l, err := e.lock(base)
+		if base.Err() != nil {
+			return e.stop(base)
+		}
+		if err != nil {
+			return err
+		}
+		if l != nil {
+			l.keep(func(holder string) {
+				fmt.Fprintf(e.out, "Lock of %s taken over by %s, stopping...\n", e.name, holder)
+				cancel()
+			})
+			defer l.unlock()
+
+			err := e.watch(base, interrupted, abandoned)
+			if holder := l.takenOverBy(); holder != "" {
+				return &lockedError{release: e.name, holder: holder, lost: true}
+			}
+			return err
+		}
+	}
+
+	return e.watch(base, interrupted, abandoned)
+}
+
+// watch waits for the release to be ready and monitors it, remediating the
+// release if a failure is detected.
+func (e *engine) watch(base context.Context, interrupted, abandoned <-chan struct{}) error {
+	var rel *release.Release
+	err := call(base, func() error {
+		res, err := e.client.ReleaseContent(e.name)
+		if err == nil {
+			rel = res.GetRelease()
+		}
+		return err
+	})
+	if base.Err() != nil {
+		return e.stop(base)
+	}
+	if err != nil {
+		return prettyError(err)
+	}
+	e.revision = rel.GetVersion()
+
+	if err := e.render(rel); err != nil {
+		return err
+	}
+
+	if e.readyTimeout > 0 {
+		fmt.Fprintf(e.out, "Waiting for %s to be deployed and ready...\n", e.name)
+
+		ctx, cancelWait := context.WithTimeout(base, e.readyTimeout)
+		err := e.waitForRelease(ctx)
+		cancelWait()
+
+		switch {
+		case base.Err() != nil:
+			return e.stop(base)
+		case err == errReleaseFailed && e.rollbackOnFailed:
+			fmt.Fprintf(e.out, "Release %s failed, %s...\n", e.name, e.action.progress())
+			return e.remediateAndVerify(base, interrupted, abandoned)
+		case err == errReleaseFailed:
+			return fmt.Errorf("release %s failed", e.name)
+		case err == context.DeadlineExceeded:
+			return fmt.Errorf("release %s not ready after %s", e.name, e.readyTimeout)
+		case isReleaseChanged(err):
+			return e.releaseChanged(err)
+		case err != nil:
+			return err
+		}
+	}
+
+	ctx, cancelSession := context.WithTimeout(base, e.timeout)
+	defer cancelSession()
+
+	if err := e.preflight(ctx); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(e.out, "Monitoring %s (revision %d)...\n", e.name, e.revision)
+
+	warmUpUntil := time.Now().Add(e.warmUp)
+	if e.warmUp > 0 {
+		fmt.Fprintf(e.out, "Warming up for %s, failures are recorded but not acted on\n", e.warmUp)
+		for _, c := range e.checks {
+			c.warmUpUntil = warmUpUntil
+		}
+	}
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			evaluations := e.evaluate(ctx, e.checks)
+
+			// the results of a session stopped while querying are incomplete
+			if ctx.Err() != nil {
+				return e.stop(ctx)
+			}
+
+			err := call(ctx, e.checkRevision)
+			if ctx.Err() != nil {
+				return e.stop(ctx)
+			}
+			if isReleaseChanged(err) {
+				return e.releaseChanged(err)
+			}
+			if err != nil {
+				fmt.Fprintf(e.out, "Could not check the revision of %s: %s\n", e.name, err)
+			}
+
+			warmingUp := time.Now().Before(warmUpUntil)
+
+			pausedBy := e.pauseReason(ctx)
+			if ctx.Err() != nil {
+				return e.stop(ctx)
+			}
+			e.pause(ctx, pausedBy)
+
+			// the rule only applies to the checks which reached a verdict,
+			// neither failing with a datasource error nor below their volume
+			failures := 0
+			verdicts := 0
+			errors := 0
+			for _, ev := range evaluations {
+				if isQueryError(ev.err) {
+					e.reportErrors()
+					return fmt.Errorf("check %s: %s", ev.check.name, ev.err)
+				}
+				if ev.err != nil {
+					fmt.Fprintf(e.out, "Datasource error on check %s: %s\n", ev.check.name, prettyError(ev.err))
+					if !warmingUp && pausedBy == "" {
+						errors++
+					}
+					continue
+				}
+
+				if ev.insufficient {
+					debug("Check %s: insufficient volume %g", ev.check.name, ev.volume)
+				} else {
+					debug("Check %s: value %g, failed %v, breached %v", ev.check.name, ev.value, ev.failed, ev.breached)
+					verdicts++
+				}
+
+				if ev.breached {
+					failures++
+				}
+			}
+
+			if warmingUp || pausedBy != "" {
+				continue
+			}
+
+			if e.rule.failed(failures, verdicts) {
+				fmt.Fprintf(e.out, "Failure detected, %s...\n", e.action.progress())
+				e.report(evaluations)
+				e.reportErrors()
+				return e.remediateAndVerify(base, interrupted, abandoned)
+			}
+
+			e.datasourceErrors += errors
+			if errors > 0 && e.datasourceErrors > e.errorBudget {
+				switch e.onDatasourceError {
+				case onDatasourceErrorAbort:
+					fmt.Fprintf(e.out, "Error budget of %d exhausted, aborting without rollback\n", e.errorBudget)
+					e.reportErrors()
+					return fmt.Errorf("datasource error budget exhausted after %d error(s)", e.datasourceErrors)
+				case onDatasourceErrorRollback:
+					fmt.Fprintf(e.out, "Error budget of %d exhausted, %s...\n", e.errorBudget, e.action.progress())
+					e.report(evaluations)
+					e.reportErrors()
+					return e.remediateAndVerify(base, interrupted, abandoned)
+				}
+			}
+
+		case <-ctx.Done():
+			return e.stop(ctx)
+		}
+	}
+}
+
+// releaseChanged ends the session without rollback because the release moved
+// to another revision than the pinned one.
+func (e *engine) releaseChanged(err error) error {
+	fmt.Fprintf(e.out, "%s, stopping without rollback\n", err)
+	e.reportErrors()
+	return err
+}
+
+// stop ends the session once its context is done, either because the timeout
+// was reached or because it was interrupted.
+func (e *engine) stop(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		fmt.Fprintf(e.out, "No results after %d second(s)\n", int64(e.timeout/time.Second))
+		e.reportVolume()
+		e.reportErrors()
+		if e.pausedBy != "" {
+			fmt.Fprintf(e.out, "Monitoring still paused, revision %d not marked as known-good\n", e.revision)
+			return nil
+		}
+		e.markKnownGood()
+		return nil
+	}
+
+	debug("Quitting...")
+	e.reportErrors()
+	return nil
+}
+
+// pause reports the pauses and resumptions of the monitoring. The session
+// keeps its deadline, a resumed session only runs for its remaining time.
+func (e *engine) pause(ctx context.Context, pausedBy string) {
+	switch {
+	case pausedBy != "" && e.pausedBy == "":
+		fmt.Fprintf(e.out, "Monitoring paused by %s, failures are recorded but not acted on\n", pausedBy)
+	case pausedBy == "" && e.pausedBy != "":
+		remaining := time.Duration(0)
+		if deadline, ok := ctx.Deadline(); ok {
+			remaining = time.Until(deadline).Round(time.Second)
+		}
+		fmt.Fprintf(e.out, "Monitoring resumed, %s remaining\n", remaining)
+	}
+	e.pausedBy = pausedBy
+}
+
+// markKnownGood records the pinned revision as known-good once it passed the
+// monitoring session, unless a check never reached its minimum volume or was
+// never evaluated successfully after the warm-up. Dry-runs are not recorded.
+func (e *engine) markKnownGood() {
+	if e.dryRun {
+		fmt.Fprintf(e.out, "Dry run, revision %d not marked as known-good\n", e.revision)
+		return
+	}
+
+	for _, c := range e.checks {
+		if c.volume != nil && !c.sufficientVolume {
+			return
+		}
+		if !c.judged {
+			fmt.Fprintf(e.out, "Check %s was never evaluated, revision %d not marked as known-good\n", c.name, e.revision)
+			return
+		}
+	}
+
+	if err := e.markRevision(recordKnownGood); err != nil {
+		fmt.Fprintf(e.out, "Could not mark revision %d as known-good: %s\n", e.revision, err)
+		return
+	}
+
+	fmt.Fprintf(e.out, "Revision %d marked as known-good\n", e.revision)
+}
+
+// markRevision records the outcome of the monitoring of the pinned revision.
+func (e *engine) markRevision(kind string) error {
+	records, err := e.records()
+	if err != nil {
+		return err
+	}
+	return records.markRevision(e.name, kind, e.revision, time.Now())
+}
+
+// records returns the records of the monitoring sessions of the releases.
+func (e *engine) records() (*releaseRecords, error) {
+	kube, err := e.kubeClient()
+	if err != nil {
+		return nil, err
+	}
+	return &releaseRecords{kube: kube, namespace: e.tillerNamespace}, nil
+}
+
+// rollbackTarget returns the revision to rollback to and the reason why it
+// was chosen: the revision given by --rollback-to, the last known-good
+// revision preceding the pinned one or the previous revision.
+func (e *engine) rollbackTarget() (int32, string, error) {
+	if e.rollbackTo > 0 {
+		if e.rollbackTo >= e.revision {
+			return 0, "", fmt.Errorf("revision %d to rollback to must precede the monitored revision %d", e.rollbackTo, e.revision)
+		}
+		return e.rollbackTo, "requested with --rollback-to", nil
+	}
+
+	if e.revision <= 1 {
+		return 0, "", fmt.Errorf("release %s has no revision preceding revision %d to rollback to", e.name, e.revision)
+	}
+
+	records, err := e.records()
+	var revisions []int32
+	if err == nil {
+		revisions, err = records.revisions(e.name, recordKnownGood)
+	}
+	if err != nil {
+		return e.revision - 1, fmt.Sprintf("the previous revision, the known-good revisions could not be read: %s", err), nil
+	}
+
+	for _, revision := range revisions {
+		if revision < e.revision {
+			return revision, "the last known-good revision", nil
+		}
+	}
+
+	return e.revision - 1, "the previous revision, no known-good revision found", nil
+}
+
+// call runs a Helm client call, which doesn't support contexts, and returns
+// as soon as the context is done, leaving the call to complete in the
+// background.
+func call(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// preflight runs the queries of every check once before monitoring. A query
+// rejected by its backend would never return any data, so monitoring aborts
+// instead of silently watching nothing. Datasource errors are only reported,
+// the backend may recover during the monitoring.
+func (e *engine) preflight(parent context.Context) error {
+	ctx, cancel := context.WithTimeout(parent, e.interval)
+	defer cancel()
+
+	for _, c := range e.checks {
+		queries := []Provider{c.provider}
+		if c.volume != nil {
+			queries = append(queries, c.volume)
+		}
+
+		for _, provider := range queries {
+			_, err := provider.Query(ctx)
+			if isQueryError(err) {
+				return fmt.Errorf("check %s: %s", c.name, err)
+			}
+			if parent.Err() != nil {
+				return nil
+			}
+			if err != nil {
+				fmt.Fprintf(e.out, "Warning: check %s: %s\n", c.name, prettyError(err))
+			}
+		}
+	}
+
+	return nil
+}
+
+// evaluate queries all the checks concurrently, each query is bounded by the
+// polling interval so that a slow backend never delays the next tick.
+func (e *engine) evaluate(parent context.Context, checks []*check) []*evaluation {
+	ctx, cancel := context.WithTimeout(parent, e.interval)
+	defer cancel()
+
+	evaluations := make([]*evaluation, len(checks))
+
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *check) {
+			defer wg.Done()
+			evaluations[i] = c.evaluate(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	return evaluations
+}
+
+// evaluate queries the provider and records the evaluation in the history of
+// the check to apply its failure policy. If the check has a volume query, the
+// provider is only queried once the volume is sufficient.
+func (c *check) evaluate(ctx context.Context) *evaluation {
+	now := time.Now()
+
+	if c.volume != nil {
+		volume, err := c.query(ctx, c.volume)
+		if err != nil {
+			return &evaluation{check: c, time: now, err: fmt.Errorf("volume query: %w", err)}
+		}
+
+		if volume.Value < float64(c.minVolume) {
+			ev := &evaluation{
+				check:        c,
+				time:         now,
+				volume:       volume.Value,
+				insufficient: true,
+			}
+			c.record(ev)
+			return ev
+		}
+
+		c.sufficientVolume = true
+	}
+
+	result, err := c.query(ctx, c.provider)
+	if err != nil {
+		return &evaluation{check: c, time: now, err: err}
+	}
+
+	value := float64(result.Count)
+	if !c.condition.count {
+		if result.Multiple {
+			return &evaluation{check: c, time: now, err: newQueryError(
+				"the condition %s requires a single series or a scalar, the query returned %d series", c.condition, result.Count)}
+		}
+		value = result.Value
+	}
+	if c.baseline == nil {
+		c.baseline = &value
+	}
+
+	ev := &evaluation{
+		check:  c,
+		time:   now,
+		result: result,
+		value:  value,
+		failed: c.condition.failed(value, *c.baseline),
+	}
+
+	if result.NoData {
+		if c.absentSince.IsZero() {
+			c.absentSince = now
+		}
+		if c.absent && now.Sub(c.absentSince) >= c.absentFor {
+			ev.absent = true
+			ev.failed = true
+		}
+	} else {
+		c.absentSince = time.Time{}
+	}
+
+	c.record(ev)
+
+	return ev
+}
+
+// query runs the query of the provider with retries, counting the errors.
+func (c *check) query(ctx context.Context, provider Provider) (*Result, error) {
+	result, failures, err := c.retry.query(ctx, provider)
+	c.requestErrors += failures
+	if err != nil {
+		c.evaluationErrors++
+		c.lastError = err
+	}
+	return result, err
+}
+
+// reset clears the history of the check to evaluate it again, the baseline of
+// relative conditions is kept.
+func (c *check) reset() {
+	c.history = nil
+	c.absentSince = time.Time{}
+	c.sufficientVolume = false
+	c.warmUpUntil = time.Time{}
+	c.judged = false
+}
+
+func (c *check) record(ev *evaluation) {
+	ev.warmUp = ev.time.Before(c.warmUpUntil)
+	if !ev.insufficient && !ev.warmUp {
+		c.judged = true
+	}
+
+	if ev.failed {
+		ev.failingSince = ev.time
+		for i := len(c.history) - 1; i >= 0; i-- {
+			if c.history[i].insufficient || c.history[i].warmUp {
+				continue
+			}
+			if c.history[i].failed {
+				ev.failingSince = c.history[i].failingSince
+			}
+			break
+		}
+	}
+
+	c.history = append(c.history, ev)
+	if size := c.policy.historySize(); len(c.history) > size {
+		c.history = c.history[len(c.history)-size:]
+	}
+
+	ev.breached = c.policy.breached(c.history)
+}
+
+// describeCondition returns when the check fails, including the baseline of
+// relative conditions.
+func (c *check) describeCondition() string {
+	if c.condition.relative && c.baseline != nil {
+		return fmt.Sprintf("failing when the change from %g is %s", *c.baseline, c.condition)
+	}
+	return fmt.Sprintf("failing when %s", c.condition)
+}
+
+// report prints the result of every check which lead to the decision, with
+// their failure policy and history of evaluations.
+func (e *engine) report(evaluations []*evaluation) {
+	failures := 0
+	for _, ev := range evaluations {
+		if ev.breached {
+			failures++
+		}
+	}
+
+	fmt.Fprintf(e.out, "%d of %d check(s) failed (rule: %s):\n", failures, len(evaluations), e.rule)
+
+	for _, ev := range evaluations {
+		status := "ok"
+		if ev.breached {
+			status = "failed"
+		} else if ev.failed {
+			status = "failing, policy not met"
+		}
+		if ev.err != nil {
+			fmt.Fprintf(e.out, "  - %s: datasource error, %s\n", ev.check.name, prettyError(ev.err))
+		} else if ev.insufficient {
+			fmt.Fprintf(e.out, "  - %s: insufficient data, volume %g lower than %d\n",
+				ev.check.name, ev.volume, ev.check.minVolume)
+		} else if ev.absent {
+			fmt.Fprintf(e.out, "  - %s: %s, no data since %s\n",
+				ev.check.name, status, ev.check.absentSince.Format("15:04:05"))
+		} else {
+			fmt.Fprintf(e.out, "  - %s: %s, value %g, %s\n",
+				ev.check.name, status, ev.value, ev.check.describeCondition())
+			if ev.result != nil && !ev.result.WindowStart.IsZero() {
+				fmt.Fprintf(e.out, "    window: %s to %s\n",
+					ev.result.WindowStart.Format("15:04:05"), ev.result.WindowEnd.Format("15:04:05"))
+			}
+			if ev.result != nil && len(ev.result.Breaching) > 0 {
+				fmt.Fprintf(e.out, "    breaching: %s\n", formatBreaching(ev.result.Breaching))
+			}
+		}
+		fmt.Fprintf(e.out, "    policy: %s\n", ev.check.policy)
+		fmt.Fprintf(e.out, "    history: %s\n", formatHistory(ev.check.history))
+	}
+}
+
+// maxBreaching is the number of breaching results printed by the report.
+const maxBreaching = 10
+
+// formatBreaching returns the list of breaching results, truncated to
+// maxBreaching results.
+func formatBreaching(breaching []string) string {
+	if len(breaching) <= maxBreaching {
+		return strings.Join(breaching, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(breaching[:maxBreaching], ", "), len(breaching)-maxBreaching)
+}
+
+// reportErrors prints the number of datasource errors of every check, if any.
+func (e *engine) reportErrors() {
+	for _, c := range e.checks {
+		if c.requestErrors == 0 {
+			continue
+		}
+		fmt.Fprintf(e.out, "Check %s: %d datasource error(s), %d evaluation(s) failed after retries",
+			c.name, c.requestErrors, c.evaluationErrors)
+		if c.lastError != nil {
+			fmt.Fprintf(e.out, ", last error: %s", prettyError(c.lastError))
+		}
+		fmt.Fprintf(e.out, "\n")
+	}
+}
+
+// reportVolume prints whether the checks with a volume query ever reached
+// the minimum volume, and thus if the release could be judged.
+func (e *engine) reportVolume() {
+	for _, c := range e.checks {
+		if c.volume == nil {
+			continue
+		}
+		if c.sufficientVolume {
+			fmt.Fprintf(e.out, "Check %s reached the minimum volume of %d\n", c.name, c.minVolume)
+		} else {
+			fmt.Fprintf(e.out, "Check %s never reached the minimum volume of %d, the release could not be judged\n", c.name, c.minVolume)
+		}
+	}
+}
@@ -0,0 +1,811 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	rls "k8s.io/helm/pkg/proto/hapi/services"
+)
+
+// fakeProvider returns the given results in order, repeating the last one.
+type fakeProvider struct {
+	results []*Result
+	err     error
+	calls   int
+}
+
+func (p *fakeProvider) Query(ctx context.Context) (*Result, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	i := p.calls
+	if i >= len(p.results) {
+		i = len(p.results) - 1
+	}
+	p.calls++
+	return p.results[i], nil
+}
+
+// fakeHelmClient records the rollbacks issued by the engine. If started is
+// set, rollbacks are notified on it and block until complete is closed. The
+// release takes the given statuses and revisions in order, repeating the last
+// ones.
+type fakeHelmClient struct {
+	helm.FakeClient
+	rollbacks int
+	started   chan struct{}
+	complete  chan struct{}
+	statuses  []release.Status_Code
+	revisions []int32
+}
+
+func (c *fakeHelmClient) ReleaseContent(rlsName string, opts ...helm.ContentOption) (*rls.GetReleaseContentResponse, error) {
+	res, err := c.FakeClient.ReleaseContent(rlsName, opts...)
+	if err == nil && len(c.statuses) > 0 {
+		res.Release.Info.Status.Code = c.statuses[0]
+		if len(c.statuses) > 1 {
+			c.statuses = c.statuses[1:]
+		}
+	}
+	if err == nil && len(c.revisions) > 0 {
+		res.Release.Version = c.revisions[0]
+		if len(c.revisions) > 1 {
+			c.revisions = c.revisions[1:]
+		}
+	}
+	return res, err
+}
+
+func (c *fakeHelmClient) RollbackRelease(rlsName string, opts ...helm.RollbackOption) (*rls.RollbackReleaseResponse, error) {
+	c.rollbacks++
+	if c.started != nil {
+		c.started <- struct{}{}
+		<-c.complete
+	}
+	return &rls.RollbackReleaseResponse{}, nil
+}
+
+func newFakeHelmClient(name string) *fakeHelmClient {
+	return &fakeHelmClient{
+		FakeClient: helm.FakeClient{
+			Rels: []*release.Release{
+				helm.ReleaseMock(&helm.MockReleaseOptions{Name: name, Version: 2}),
+				helm.ReleaseMock(&helm.MockReleaseOptions{Name: name, Version: 1}),
+			},
+		},
+	}
+}
+
+func newTestEngine(client helm.Interface, rule combinationRule, onDatasourceError string, providers ...*fakeProvider) *engine {
+	checks := []*check{}
+	for i, p := range providers {
+		checks = append(checks, &check{
+			name:      fmt.Sprintf("check-%d", i),
+			provider:  p,
+			condition: newCountCondition(0),
+			policy:    failurePolicy{consecutiveFailures: 1},
+		})
+	}
+
+	return &engine{
+		name:     "my-release",
+		out:      ioutil.Discard,
+		client:   client,
+		checks:   checks,
+		rule:     rule,
+		interval: time.Millisecond,
+		timeout:  50 * time.Millisecond,
+
+		onDatasourceError: onDatasourceError,
+		kube:              fake.NewSimpleClientset(),
+		tillerNamespace:   "kube-system",
+	}
+}
+
+func TestEngineRun(t *testing.T) {
+	failing := func() *fakeProvider { return &fakeProvider{results: []*Result{{Count: 0}, {Count: 2}}} }
+	passing := func() *fakeProvider { return &fakeProvider{results: []*Result{{Count: 0}}} }
+
+	for _, test := range []struct {
+		name              string
+		rule              combinationRule
+		onDatasourceError string
+		providers         []*fakeProvider
+		expectedRollbacks int
+		expectedErr       bool
+	}{
+		{
+			name:              "it should rollback when the result count exceeds the expected count",
+			rule:              combinationRule{kind: ruleAny},
+			providers:         []*fakeProvider{failing()},
+			expectedRollbacks: 1,
+		},
+		{
+			name:              "it should not rollback when the result count never exceeds the expected count",
+			rule:              combinationRule{kind: ruleAny},
+			providers:         []*fakeProvider{passing()},
+			expectedRollbacks: 0,
+		},
+		{
+			name:              "it should abort monitoring when the provider fails",
+			rule:              combinationRule{kind: ruleAny},
+			onDatasourceError: onDatasourceErrorAbort,
+			providers:         []*fakeProvider{{err: errors.New("connection refused")}},
+			expectedRollbacks: 0,
+			expectedErr:       true,
+		},
+		{
+			name:              "it should keep monitoring when the provider fails with the keep policy",
+			rule:              combinationRule{kind: ruleAny},
+			onDatasourceError: onDatasourceErrorKeep,
+			providers:         []*fakeProvider{{err: errors.New("connection refused")}},
+			expectedRollbacks: 0,
+		},
+		{
+			name:              "it should rollback when the provider fails with the rollback policy",
+			rule:              combinationRule{kind: ruleAny},
+			onDatasourceError: onDatasourceErrorRollback,
+			providers:         []*fakeProvider{{err: errors.New("connection refused")}},
+			expectedRollbacks: 1,
+		},
+		{
+			name:              "it should abort without rollback when the query is rejected",
+			rule:              combinationRule{kind: ruleAny},
+			onDatasourceError: onDatasourceErrorRollback,
+			providers:         []*fakeProvider{{err: newQueryError("parse error")}},
+			expectedRollbacks: 0,
+			expectedErr:       true,
+		},
+		{
+			name:              "it should rollback once when any of the checks fails",
+			rule:              combinationRule{kind: ruleAny},
+			providers:         []*fakeProvider{failing(), passing(), failing()},
+			expectedRollbacks: 1,
+		},
+		{
+			name:              "it should not rollback when only some of the checks fail with the all rule",
+			rule:              combinationRule{kind: ruleAll},
+			providers:         []*fakeProvider{failing(), passing()},
+			expectedRollbacks: 0,
+		},
+		{
+			name:              "it should rollback when all the checks fail with the all rule",
+			rule:              combinationRule{kind: ruleAll},
+			providers:         []*fakeProvider{failing(), failing()},
+			expectedRollbacks: 1,
+		},
+		{
+			name:              "it should leave the checks failing with a datasource error out of the all rule",
+			rule:              combinationRule{kind: ruleAll},
+			onDatasourceError: onDatasourceErrorKeep,
+			providers:         []*fakeProvider{failing(), {err: errors.New("connection refused")}},
+			expectedRollbacks: 1,
+		},
+		{
+			name:              "it should rollback when the quorum of failed checks is reached",
+			rule:              combinationRule{kind: ruleQuorum, quorum: 2},
+			providers:         []*fakeProvider{failing(), passing(), failing()},
+			expectedRollbacks: 1,
+		},
+		{
+			name:              "it should not rollback when the quorum of failed checks is not reached",
+			rule:              combinationRule{kind: ruleQuorum, quorum: 2},
+			providers:         []*fakeProvider{failing(), passing(), passing()},
+			expectedRollbacks: 0,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			client := newFakeHelmClient("my-release")
+			onDatasourceError := test.onDatasourceError
+			if onDatasourceError == "" {
+				onDatasourceError = onDatasourceErrorKeep
+			}
+
+			err := newTestEngine(client, test.rule, onDatasourceError, test.providers...).run(context.Background())
+			if client.rollbacks != test.expectedRollbacks || (err != nil) != test.expectedErr {
+				t.Errorf(
+					"\ngiven %v\nexpected: %d rollback(s), error %v\ngot: %d rollback(s), error %v\n",
+					spew.Sdump(test.providers),
+					test.expectedRollbacks,
+					test.expectedErr,
+					client.rollbacks,
+					err,
+				)
+			}
+		})
+	}
+}
+
+func TestEngineWaitForRelease(t *testing.T) {
+	for _, test := range []struct {
+		name              string
+		statuses          []release.Status_Code
+		rollbackOnFailed  bool
+		warmUp            time.Duration
+		expectedRollbacks int
+		expectedErr       bool
+	}{
+		{
+			name:              "it should start monitoring once the release is deployed",
+			statuses:          []release.Status_Code{release.Status_PENDING_UPGRADE, release.Status_DEPLOYED},
+			expectedRollbacks: 1,
+		},
+		{
+			name:        "it should fail when the release is never deployed",
+			statuses:    []release.Status_Code{release.Status_PENDING_UPGRADE},
+			expectedErr: true,
+		},
+		{
+			name:        "it should fail without rollback when the release failed",
+			statuses:    []release.Status_Code{release.Status_PENDING_UPGRADE, release.Status_FAILED},
+			expectedErr: true,
+		},
+		{
+			name:              "it should rollback when the release failed with --rollback-on-failed-release",
+			statuses:          []release.Status_Code{release.Status_PENDING_UPGRADE, release.Status_FAILED},
+			rollbackOnFailed:  true,
+			expectedRollbacks: 1,
+		},
+		{
+			name:              "it should not act on failures during the warm-up",
+			statuses:          []release.Status_Code{release.Status_DEPLOYED},
+			warmUp:            time.Minute,
+			expectedRollbacks: 0,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			client := newFakeHelmClient("my-release")
+			client.statuses = test.statuses
+
+			e := newTestEngine(client, combinationRule{kind: ruleAny}, onDatasourceErrorAbort,
+				&fakeProvider{results: []*Result{{Count: 2}}})
+			e.readyTimeout = 20 * time.Millisecond
+			e.rollbackOnFailed = test.rollbackOnFailed
+			e.warmUp = test.warmUp
+
+			err := e.run(context.Background())
+			if client.rollbacks != test.expectedRollbacks || (err != nil) != test.expectedErr {
+				t.Errorf(
+					"\ngiven %v\nexpected: %d rollback(s), error %v\ngot: %d rollback(s), error %v\n",
+					test.statuses,
+					test.expectedRollbacks,
+					test.expectedErr,
+					client.rollbacks,
+					err,
+				)
+			}
+		})
+	}
+}
+
+func TestEngineRevision(t *testing.T) {
+	for _, test := range []struct {
+		name              string
+		revisions         []int32
+		expectedRollbacks int
+		expectedStatus    int
+	}{
+		{
+			name:              "it should rollback the pinned revision",
+			revisions:         []int32{2},
+			expectedRollbacks: 1,
+		},
+		{
+			name:           "it should stop without rollback when the release is upgraded while polling",
+			revisions:      []int32{2, 3},
+			expectedStatus: exitReleaseChanged,
+		},
+		{
+			name:           "it should not rollback when the release is upgraded before the rollback",
+			revisions:      []int32{2, 2, 3},
+			expectedStatus: exitReleaseChanged,
+		},
+		{
+			name:           "it should not rollback the first revision",
+			revisions:      []int32{1},
+			expectedStatus: exitFailure,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			client := newFakeHelmClient("my-release")
+			client.revisions = test.revisions
+
+			err := newTestEngine(client, combinationRule{kind: ruleAny}, onDatasourceErrorAbort,
+				&fakeProvider{results: []*Result{{Count: 2}}}).run(context.Background())
+
+			status := 0
+			if err != nil {
+				status = exitStatus(err)
+			}
+			if client.rollbacks != test.expectedRollbacks || status != test.expectedStatus {
+				t.Errorf(
+					"\ngiven %v\nexpected: %d rollback(s), exit status %d\ngot: %d rollback(s), exit status %d (%v)\n",
+					test.revisions,
+					test.expectedRollbacks,
+					test.expectedStatus,
+					client.rollbacks,
+					status,
+					err,
+				)
+			}
+		})
+	}
+}
+
+func TestEngineRollbackTarget(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		rollbackTo  int32
+		knownGood   []int32
+		expected    int32
+		expectedErr bool
+	}{
+		{
+			name:     "it should rollback to the previous revision without known-good revision",
+			expected: 4,
+		},
+		{
+			name:      "it should rollback to the last known-good revision",
+			knownGood: []int32{1, 3},
+			expected:  3,
+		},
+		{
+			name:      "it should ignore known-good revisions following the pinned one",
+			knownGood: []int32{2, 6},
+			expected:  2,
+		},
+		{
+			name:       "it should rollback to the requested revision",
+			rollbackTo: 1,
+			knownGood:  []int32{3},
+			expected:   1,
+		},
+		{
+			name:        "it should not rollback to a revision following the pinned one",
+			rollbackTo:  6,
+			expectedErr: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			e := newTestEngine(newFakeHelmClient("my-release"), combinationRule{kind: ruleAny}, onDatasourceErrorAbort)
+			e.revision = 5
+			e.rollbackTo = test.rollbackTo
+
+			store := &releaseRecords{kube: e.kube, namespace: e.tillerNamespace}
+			for _, revision := range test.knownGood {
+				if err := store.markRevision(e.name, recordKnownGood, revision, time.Now()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			output, _, err := e.rollbackTarget()
+			if output != test.expected || (err != nil) != test.expectedErr {
+				t.Errorf(
+					"\ngiven known-good revisions %v\nexpected: revision %d, error %v\ngot: revision %d, error %v\n",
+					test.knownGood,
+					test.expected,
+					test.expectedErr,
+					output,
+					err,
+				)
+			}
+		})
+	}
+}
+
+func TestEngineSafeguards(t *testing.T) {
+	for _, test := range []struct {
+		name               string
+		rollbacks          int
+		failed             []int32
+		chartVersion       string
+		allowMajorRollback bool
+		expectedRollbacks  int
+		expectedStatus     int
+	}{
+		{
+			name:              "it should rollback when no safeguard applies",
+			rollbacks:         1,
+			chartVersion:      "0.2.0",
+			expectedRollbacks: 1,
+		},
+		{
+			name:           "it should refuse to rollback more than the maximum number of rollbacks",
+			rollbacks:      2,
+			expectedStatus: exitRollbackBlocked,
+		},
+		{
+			name:           "it should refuse to rollback to a revision which failed monitoring",
+			failed:         []int32{1},
+			expectedStatus: exitRollbackBlocked,
+		},
+		{
+			name:           "it should refuse to rollback across a chart major version",
+			chartVersion:   "1.0.0",
+			expectedStatus: exitRollbackBlocked,
+		},
+		{
+			name:               "it should rollback across a chart major version when allowed",
+			chartVersion:       "1.0.0",
+			allowMajorRollback: true,
+			expectedRollbacks:  1,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			client := newFakeHelmClient("my-release")
+			if test.chartVersion != "" {
+				client.Rels[1].Chart.Metadata.Version = test.chartVersion
+			}
+
+			e := newTestEngine(client, combinationRule{kind: ruleAny}, onDatasourceErrorAbort,
+				&fakeProvider{results: []*Result{{Count: 2}}})
+			e.maxRollbacks = 2
+			e.rollbackWindow = time.Hour
+			e.allowMajorRollback = test.allowMajorRollback
+
+			records := &releaseRecords{kube: e.kube, namespace: e.tillerNamespace}
+			for i := 0; i < test.rollbacks; i++ {
+				if err := records.addRollback(e.name, 3, 2, time.Now().Add(-time.Duration(i)*time.Minute), e.rollbackWindow); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, revision := range test.failed {
+				if err := records.markRevision(e.name, recordFailed, revision, time.Now()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := e.run(context.Background())
+
+			status := 0
+			if err != nil {
+				status = exitStatus(err)
+			}
+			if client.rollbacks != test.expectedRollbacks || status != test.expectedStatus {
+				t.Errorf(
+					"\nexpected: %d rollback(s), exit status %d\ngot: %d rollback(s), exit status %d (%v)\n",
+					test.expectedRollbacks,
+					test.expectedStatus,
+					client.rollbacks,
+					status,
+					err,
+				)
+			}
+		})
+	}
+}
+
+func TestEngineVerify(t *testing.T) {
+	for _, test := range []struct {
+		name           string
+		results        []*Result
+		verifyResults  []*Result
+		expectedStatus int
+	}{
+		{
+			name:    "it should succeed when the system recovered after the rollback",
+			results: []*Result{{Count: 0}, {Count: 2}, {Count: 0}},
+		},
+		{
+			name:           "it should fail when the system is still failing after the rollback",
+			results:        []*Result{{Count: 2}},
+			expectedStatus: exitNotRecovered,
+		},
+		{
+			name:          "it should succeed when the verification checks pass",
+			results:       []*Result{{Count: 2}},
+			verifyResults: []*Result{{Count: 0}},
+		},
+		{
+			name:           "it should fail when the verification checks fail",
+			results:        []*Result{{Count: 0}, {Count: 2}, {Count: 0}},
+			verifyResults:  []*Result{{Count: 3}},
+			expectedStatus: exitNotRecovered,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			client := newFakeHelmClient("my-release")
+			e := newTestEngine(client, combinationRule{kind: ruleAny}, onDatasourceErrorAbort,
+				&fakeProvider{results: test.results})
+			e.verifyDuration = 20 * time.Millisecond
+			if test.verifyResults != nil {
+				e.verifyChecks = newTestEngine(client, e.rule, e.onDatasourceError,
+					&fakeProvider{results: test.verifyResults}).checks
+			}
+
+			err := e.run(context.Background())
+
+			status := 0
+			if err != nil {
+				status = exitStatus(err)
+			}
+			if client.rollbacks != 1 || status != test.expectedStatus {
+				t.Errorf(
+					"\ngiven %v\nexpected: 1 rollback, exit status %d\ngot: %d rollback(s), exit status %d (%v)\n",
+					spew.Sdump(test.results),
+					test.expectedStatus,
+					client.rollbacks,
+					status,
+					err,
+				)
+			}
+		})
+	}
+}
+
+func TestEngineMarkKnownGood(t *testing.T) {
+	for _, test := range []struct {
+		name              string
+		onDatasourceError string
+		provider          *fakeProvider
+		warmUp            time.Duration
+		dryRun            bool
+		expected          []int32
+	}{
+		{
+			name:              "it should mark the revision as known-good when the checks passed",
+			onDatasourceError: onDatasourceErrorAbort,
+			provider:          &fakeProvider{results: []*Result{{Count: 0}}},
+			expected:          []int32{2},
+		},
+		{
+			name:              "it should not mark the revision when every query failed",
+			onDatasourceError: onDatasourceErrorKeep,
+			provider:          &fakeProvider{err: errors.New("connection refused")},
+			expected:          []int32{},
+		},
+		{
+			name:              "it should not mark the revision when the warm-up lasted the whole session",
+			onDatasourceError: onDatasourceErrorAbort,
+			provider:          &fakeProvider{results: []*Result{{Count: 0}}},
+			warmUp:            time.Minute,
+			expected:          []int32{},
+		},
+		{
+			name:              "it should not mark the revision on dry-run",
+			onDatasourceError: onDatasourceErrorAbort,
+			provider:          &fakeProvider{results: []*Result{{Count: 0}}},
+			dryRun:            true,
+			expected:          []int32{},
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			client := newFakeHelmClient("my-release")
+			e := newTestEngine(client, combinationRule{kind: ruleAny}, test.onDatasourceError, test.provider)
+			e.warmUp = test.warmUp
+			e.dryRun = test.dryRun
+
+			if err := e.run(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			revisions, err := (&releaseRecords{kube: e.kube, namespace: e.tillerNamespace}).revisions(e.name, recordKnownGood)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(revisions) != len(test.expected) || (len(revisions) > 0 && !reflect.DeepEqual(revisions, test.expected)) {
+				t.Errorf("expected %v to be marked as known-good, got %v", test.expected, revisions)
+			}
+		})
+	}
+}
+
+func TestEngineInterrupt(t *testing.T) {
+	t.Run("it should stop monitoring on the first signal", func(t *testing.T) {
+		client := newFakeHelmClient("my-release")
+		e := newTestEngine(client, combinationRule{kind: ruleAny}, onDatasourceErrorAbort,
+			&fakeProvider{results: []*Result{{Count: 0}}})
+		e.timeout = time.Minute
+
+		quit := make(chan os.Signal, 1)
+		quit <- syscall.SIGINT
+
+		err := e.session(context.Background(), quit)
+		if err != nil || client.rollbacks != 0 {
+			t.Errorf("\nexpected: no rollback, no error\ngot: %d rollback(s), error %v\n", client.rollbacks, err)
+		}
+	})
+
+	for _, test := range []struct {
+		name        string
+		signals     int
+		expectedErr bool
+	}{
+		{
+			name:    "it should wait for the rollback to complete on the first signal",
+			signals: 1,
+		},
+		{
+			name:        "it should abandon the rollback on the second signal",
+			signals:     2,
+			expectedErr: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			client := newFakeHelmClient("my-release")
+			client.started = make(chan struct{})
+			client.complete = make(chan struct{})
+			defer close(client.complete)
+
+			e := newTestEngine(client, combinationRule{kind: ruleAny}, onDatasourceErrorAbort,
+				&fakeProvider{results: []*Result{{Count: 2}}})
+			e.timeout = time.Minute
+
+			quit := make(chan os.Signal)
+			done := make(chan error, 1)
+			go func() {
+				done <- e.session(context.Background(), quit)
+			}()
+
+			<-client.started
+			for i := 0; i < test.signals; i++ {
+				quit <- syscall.SIGINT
+			}
+
+			var err error
+			if test.signals < 2 {
+				select {
+				case err = <-done:
+					t.Fatalf("expected the session to wait for the rollback, got %v", err)
+				case <-time.After(20 * time.Millisecond):
+				}
+				client.complete <- struct{}{}
+			}
+
+			err = <-done
+			if (err != nil) != test.expectedErr {
+				t.Errorf("\nexpected error: %v\ngot: %v\n", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestCheckAbsent(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		absent    bool
+		absentFor time.Duration
+		results   []*Result
+		expected  []bool
+	}{
+		{
+			name:     "it should not fail on absent data by default",
+			results:  []*Result{{NoData: true}, {NoData: true}},
+			expected: []bool{false, false},
+		},
+		{
+			name:     "it should fail as soon as data is absent",
+			absent:   true,
+			results:  []*Result{{Count: 1}, {NoData: true}},
+			expected: []bool{false, true},
+		},
+		{
+			name:      "it should fail once data is absent for the given duration",
+			absent:    true,
+			absentFor: 10 * time.Millisecond,
+			results:   []*Result{{NoData: true}, {NoData: true}, {Count: 1}, {NoData: true}},
+			expected:  []bool{false, true, false, false},
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			c := &check{
+				provider:  &fakeProvider{results: test.results},
+				condition: newCountCondition(1),
+				policy:    failurePolicy{consecutiveFailures: 1},
+				absent:    test.absent,
+				absentFor: test.absentFor,
+			}
+
+			output := []bool{}
+			for range test.results {
+				output = append(output, c.evaluate(context.Background()).failed)
+				time.Sleep(test.absentFor)
+			}
+
+			if !reflect.DeepEqual(output, test.expected) {
+				t.Errorf("\ngiven %v\nexpected: %v\ngot: %v\n", spew.Sdump(test.results), test.expected, output)
+			}
+		})
+	}
+}
+
+func TestCheckCondition(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		condition     string
+		results       []*Result
+		expected      []bool
+		expectedError bool
+	}{
+		{
+			name:      "it should compare the value of a single series",
+			condition: "< 100",
+			results:   []*Result{{Count: 1, Value: 150}, {Count: 1, Value: 50}},
+			expected:  []bool{false, true},
+		},
+		{
+			name:      "it should compare the change of the value from the baseline",
+			condition: "< -20%",
+			results:   []*Result{{Count: 1, Value: 100}, {Count: 1, Value: 90}, {Count: 1, Value: 70}},
+			expected:  []bool{false, false, true},
+		},
+		{
+			name:          "it should reject a condition on several series",
+			condition:     "< 100",
+			results:       []*Result{{Count: 2, Value: 150, Multiple: true}},
+			expectedError: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			cond, err := parseCondition(test.condition)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := &check{
+				provider:  &fakeProvider{results: test.results},
+				condition: cond,
+				policy:    failurePolicy{consecutiveFailures: 1},
+			}
+
+			output := []bool{}
+			for range test.results {
+				ev := c.evaluate(context.Background())
+				if ev.err != nil {
+					if !test.expectedError || !isQueryError(ev.err) {
+						t.Errorf("unexpected error %v", ev.err)
+					}
+					return
+				}
+				output = append(output, ev.failed)
+			}
+
+			if test.expectedError || !reflect.DeepEqual(output, test.expected) {
+				t.Errorf("\ngiven %v\nexpected: %v, error %v\ngot: %v\n", spew.Sdump(test.results), test.expected, test.expectedError, output)
+			}
+		})
+	}
+}
+
+func TestCheckVolume(t *testing.T) {
+	c := &check{
+		provider:  &fakeProvider{results: []*Result{{Count: 5}}},
+		condition: newCountCondition(0),
+		policy:    failurePolicy{consecutiveFailures: 2},
+		volume: &fakeProvider{results: []*Result{
+			{Value: 100}, {Value: 2}, {Value: 100},
+		}},
+		minVolume: 10,
+	}
+
+	expected := []struct {
+		insufficient bool
+		breached     bool
+	}{
+		{insufficient: false, breached: false},
+		{insufficient: true, breached: false},
+		{insufficient: false, breached: true},
+	}
+
+	for i, e := range expected {
+		ev := c.evaluate(context.Background())
+		if ev.insufficient != e.insufficient || ev.breached != e.breached {
+			t.Errorf("\nevaluation %d\nexpected: insufficient %v, breached %v\ngot: insufficient %v, breached %v\n",
+				i, e.insufficient, e.breached, ev.insufficient, ev.breached)
+		}
+	}
+
+	if !c.sufficientVolume {
+		t.Errorf("expected the check to have reached the minimum volume")
+	}
+}
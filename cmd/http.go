@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// httpOptions configures the authentication, TLS and proxy of the HTTP client
+// of a provider. The secrets are only sent in the headers of the requests,
+// never printed.
+type httpOptions struct {
+	basicAuthUser      string
+	basicAuthPassword  string
+	bearerToken        string
+	bearerTokenFile    string
+	caFile             string
+	certFile           string
+	keyFile            string
+	insecureSkipVerify bool
+	proxyURL           string
+}
+
+func (o *httpOptions) addFlags(f *pflag.FlagSet) {
+	o.addPrefixedFlags(f, "")
+}
+
+// addPrefixedFlags adds the flags with their names prefixed, ie:
+// --pause-bearer-token for the pause prefix.
+func (o *httpOptions) addPrefixedFlags(f *pflag.FlagSet, prefix string) {
+	name := func(n string) string {
+		if prefix == "" {
+			return n
+		}
+		return prefix + "-" + n
+	}
+
+	f.StringVar(&o.basicAuthUser, name("basic-auth-user"), "", "username of the basic authentication")
+	f.StringVar(&o.basicAuthPassword, name("basic-auth-password"), "", "password of the basic authentication")
+	f.StringVar(&o.bearerToken, name("bearer-token"), "", "bearer token sent in the Authorization header")
+	f.StringVar(&o.bearerTokenFile, name("bearer-token-file"), "", "file containing the bearer token, read at every request")
+	f.StringVar(&o.caFile, name("ca-file"), "", "CA bundle verifying the server certificate")
+	f.StringVar(&o.certFile, name("cert-file"), "", "client certificate file")
+	f.StringVar(&o.keyFile, name("key-file"), "", "client certificate key file")
+	f.BoolVar(&o.insecureSkipVerify, name("insecure-skip-verify"), false, "skip the verification of the server certificate")
+	f.StringVar(&o.proxyURL, name("proxy-url"), "", "HTTP proxy, defaults to the HTTP_PROXY and HTTPS_PROXY environment variables")
+}
+
+// Errors returned by the validation of the options.
+var (
+	errBearerTokenConflict = errors.New("bearer token and bearer token file are mutually exclusive")
+	errAuthConflict        = errors.New("basic authentication and bearer token are mutually exclusive")
+	errClientCertificate   = errors.New("client certificate and key must be given together")
+	errProxyURL            = errors.New("invalid proxy URL")
+)
+
+// validate checks that the options are consistent.
+func (o *httpOptions) validate() error {
+	if o.bearerToken != "" && o.bearerTokenFile != "" {
+		return errBearerTokenConflict
+	}
+	if o.basicAuthUser != "" && (o.bearerToken != "" || o.bearerTokenFile != "") {
+		return errAuthConflict
+	}
+	if (o.certFile == "") != (o.keyFile == "") {
+		return errClientCertificate
+	}
+	// the parsing error would print the credentials of the proxy
+	if o.proxyURL != "" {
+		if _, err := url.Parse(o.proxyURL); err != nil {
+			return errProxyURL
+		}
+	}
+	return nil
+}
+
+// client returns an HTTP client configured with the options.
+func (o *httpOptions) client(timeout time.Duration) (*http.Client, error) {
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: o.insecureSkipVerify}
+
+	if o.caFile != "" {
+		ca, err := ioutil.ReadFile(o.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the CA bundle: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in the CA bundle %s", o.caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if o.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load the client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
+	if o.proxyURL != "" {
+		proxy, _ := url.Parse(o.proxyURL)
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &authTransport{base: transport, options: o},
+	}, nil
+}
+
+// redactURL hides the password of a URL before it is printed, any other
+// string is returned as is.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	return u.Redacted()
+}
+
+// authTransport sets the Authorization header of every request.
+type authTransport struct {
+	base    http.RoundTripper
+	options *httpOptions
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	o := t.options
+
+	token := o.bearerToken
+	if o.bearerTokenFile != "" {
+		data, err := ioutil.ReadFile(o.bearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the bearer token: %s", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	if token == "" && o.basicAuthUser == "" {
+		return t.base.RoundTrip(req)
+	}
+
+	// a request must not be modified by a RoundTripper
+	req = req.Clone(req.Context())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		req.SetBasicAuth(o.basicAuthUser, o.basicAuthPassword)
+	}
+
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Behaviors of a monitor finding the release locked by another monitor.
+const (
+	onLockedFail     = "fail"
+	onLockedWait     = "wait"
+	onLockedTakeOver = "take-over"
+)
+
+func validateOnLocked(onLocked string) error {
+	switch onLocked {
+	case onLockedFail, onLockedWait, onLockedTakeOver:
+		return nil
+	}
+	return fmt.Errorf("unknown locked policy %q, expected one of %s, %s, %s", onLocked, onLockedFail, onLockedWait, onLockedTakeOver)
+}
+
+// lockedError is returned when the release is locked by another monitor, or
+// when the lock was taken over by another monitor during the session.
+type lockedError struct {
+	release   string
+	holder    string
+	renewedAt time.Time
+	stale     bool
+	lost      bool
+}
+
+func (e *lockedError) Error() string {
+	if e.lost {
+		return fmt.Sprintf("lock of release %s taken over by %s", e.release, e.holder)
+	}
+	message := fmt.Sprintf("release %s is locked by %s, renewed at %s", e.release, e.holder, e.renewedAt.Format(time.RFC3339))
+	if e.stale {
+		message += ", the lock is stale and can be taken over with --on-locked take-over"
+	}
+	return message
+}
+
+func isLocked(err error) bool {
+	var locked *lockedError
+	return errors.As(err, &locked)
+}
+
+// lease is the lock of a release held by a monitor, stored in a ConfigMap of
+// the Tiller namespace. It is renewed while the monitor runs and considered
+// stale once it wasn't renewed for its duration.
+type lease struct {
+	kube      kubernetes.Interface
+	namespace string
+	release   string
+	holder    string
+	duration  time.Duration
+
+	mu     sync.Mutex
+	lostTo string
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// configMapName returns the name of the ConfigMap holding the lock.
+func (l *lease) configMapName() string {
+	return "helm-monitor." + l.release + ".lock"
+}
+
+// lockHolder returns the identity of the monitor holding the lock.
+func lockHolder() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// lock acquires the lock of the release following the locked policy. A lock
+// which can't be read is handled as a held lock: the monitor fails, or waits
+// until the lock can be read, the release is never monitored without lock.
+func (e *engine) lock(ctx context.Context) (*lease, error) {
+	kube, err := e.kubeClient()
+	if err != nil {
+		return nil, fmt.Errorf("could not lock %s: %s", e.name, err)
+	}
+
+	l := &lease{
+		kube:      kube,
+		namespace: e.tillerNamespace,
+		release:   e.name,
+		holder:    lockHolder(),
+		duration:  e.lockDuration,
+	}
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	waiting := false
+	for {
+		err := l.acquire(time.Now(), e.onLocked != onLockedFail)
+		if err == nil {
+			debug("Locked %s as %s", e.name, l.holder)
+			return l, nil
+		}
+
+		if !isLocked(err) {
+			err = fmt.Errorf("could not lock %s: %s", e.name, err)
+		}
+		if e.onLocked != onLockedWait {
+			return nil, err
+		}
+		if !waiting {
+			fmt.Fprintf(e.out, "%s, waiting...\n", err)
+			waiting = true
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// acquire creates the lock, or takes it over if it is stale and takeStale is
+// set. It returns a lockedError if the lock is held by another monitor.
+func (l *lease) acquire(now time.Time, takeStale bool) error {
+	configMaps := l.kube.CoreV1().ConfigMaps(l.namespace)
+
+	cm, err := configMaps.Get(l.configMapName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   l.configMapName(),
+				Labels: map[string]string{"NAME": l.release, "OWNER": "HELM_MONITOR"},
+			},
+			Data: l.data(now, now),
+		})
+		if apierrors.IsAlreadyExists(err) {
+			return &lockedError{release: l.release, holder: "another monitor", renewedAt: now}
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	holder, renewedAt, duration := parseLease(cm.Data)
+	stale := now.Sub(renewedAt) > duration
+	if holder != l.holder && (!stale || !takeStale) {
+		return &lockedError{release: l.release, holder: holder, renewedAt: renewedAt, stale: stale}
+	}
+
+	// the update fails if another monitor updated the lock in the meantime
+	cm.Data = l.data(now, now)
+	_, err = configMaps.Update(cm)
+	if apierrors.IsConflict(err) {
+		return &lockedError{release: l.release, holder: "another monitor", renewedAt: now}
+	}
+	return err
+}
+
+func (l *lease) data(acquiredAt, renewedAt time.Time) map[string]string {
+	return map[string]string{
+		"holder":     l.holder,
+		"acquiredAt": acquiredAt.Format(time.RFC3339),
+		"renewedAt":  renewedAt.Format(time.RFC3339),
+		"duration":   strconv.FormatInt(int64(l.duration/time.Second), 10),
+	}
+}
+
+// parseLease returns the holder, the last renewal and the duration of a lock,
+// a lock which can't be parsed is stale.
+func parseLease(data map[string]string) (string, time.Time, time.Duration) {
+	renewedAt, _ := time.Parse(time.RFC3339, data["renewedAt"])
+	seconds, _ := strconv.ParseInt(data["duration"], 10, 64)
+	return data["holder"], renewedAt, time.Duration(seconds) * time.Second
+}
+
+// keep renews the lock every third of its duration until it is released. If
+// another monitor took the lock over, lost is called once.
+func (l *lease) keep(lost func(holder string)) {
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+
+	go func() {
+		defer close(l.done)
+
+		ticker := time.NewTicker(l.duration / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-l.stop:
+				return
+			}
+
+			holder, err := l.renew(time.Now())
+			if err != nil {
+				debug("Could not renew the lock of %s: %s", l.release, err)
+				continue
+			}
+			if holder != l.holder {
+				l.mu.Lock()
+				l.lostTo = holder
+				l.mu.Unlock()
+				lost(holder)
+				return
+			}
+		}
+	}()
+}
+
+// renew updates the renewal time of the lock, unless another monitor holds it.
+// It returns the holder of the lock.
+func (l *lease) renew(now time.Time) (string, error) {
+	configMaps := l.kube.CoreV1().ConfigMaps(l.namespace)
+
+	// a deleted lock is acquired again, unless another monitor acquired it
+	// in the meantime
+	cm, err := configMaps.Get(l.configMapName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		err = l.acquire(now, false)
+		var locked *lockedError
+		if errors.As(err, &locked) {
+			return locked.holder, nil
+		}
+		return l.holder, err
+	}
+	if err != nil {
+		return "", err
+	}
+
+	holder, _, _ := parseLease(cm.Data)
+	if holder != l.holder {
+		return holder, nil
+	}
+
+	cm.Data["renewedAt"] = now.Format(time.RFC3339)
+	_, err = configMaps.Update(cm)
+	return holder, err
+}
+
+// takenOverBy returns the monitor which took the lock over, empty if the lock
+// is still held.
+func (l *lease) takenOverBy() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lostTo
+}
+
+// unlock stops renewing the lock and deletes it, unless it was taken over.
+func (l *lease) unlock() {
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+	}
+
+	configMaps := l.kube.CoreV1().ConfigMaps(l.namespace)
+	cm, err := configMaps.Get(l.configMapName(), metav1.GetOptions{})
+	if err != nil {
+		return
+	}
+	if holder, _, _ := parseLease(cm.Data); holder != l.holder {
+		return
+	}
+
+	uid := cm.UID
+	err = configMaps.Delete(l.configMapName(), &metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}})
+	if err != nil {
+		debug("Could not release the lock of %s: %s", l.release, err)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+func TestEngineLock(t *testing.T) {
+	for _, test := range []struct {
+		name              string
+		onLocked          string
+		renewedAgo        time.Duration
+		releaseAfter      time.Duration
+		readErrors        int
+		expectedRollbacks int
+		expectedStatus    int
+	}{
+		{
+			name:              "it should lock an unlocked release",
+			onLocked:          onLockedFail,
+			expectedRollbacks: 1,
+		},
+		{
+			name:           "it should fail when the release is locked",
+			onLocked:       onLockedFail,
+			renewedAgo:     time.Second,
+			expectedStatus: exitLocked,
+		},
+		{
+			name:           "it should fail when the release is locked by a stale lock",
+			onLocked:       onLockedFail,
+			renewedAgo:     time.Hour,
+			expectedStatus: exitLocked,
+		},
+		{
+			name:           "it should not take over a lock which isn't stale",
+			onLocked:       onLockedTakeOver,
+			renewedAgo:     time.Second,
+			expectedStatus: exitLocked,
+		},
+		{
+			name:              "it should take over a stale lock",
+			onLocked:          onLockedTakeOver,
+			renewedAgo:        time.Hour,
+			expectedRollbacks: 1,
+		},
+		{
+			name:              "it should wait for the lock to be released",
+			onLocked:          onLockedWait,
+			renewedAgo:        time.Second,
+			releaseAfter:      20 * time.Millisecond,
+			expectedRollbacks: 1,
+		},
+		{
+			name:           "it should fail when the lock can't be read",
+			onLocked:       onLockedFail,
+			readErrors:     1,
+			expectedStatus: exitFailure,
+		},
+		{
+			name:           "it should not take over a lock which can't be read",
+			onLocked:       onLockedTakeOver,
+			readErrors:     1,
+			expectedStatus: exitFailure,
+		},
+		{
+			name:              "it should wait for the lock to be readable",
+			onLocked:          onLockedWait,
+			readErrors:        3,
+			expectedRollbacks: 1,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			client := newFakeHelmClient("my-release")
+			e := newTestEngine(client, combinationRule{kind: ruleAny}, onDatasourceErrorAbort,
+				&fakeProvider{results: []*Result{{Count: 2}}})
+			e.onLocked = test.onLocked
+			e.lockDuration = time.Minute
+
+			readErrors := test.readErrors
+			e.kube.(*fake.Clientset).PrependReactor("get", "configmaps", func(action ktesting.Action) (bool, runtime.Object, error) {
+				if readErrors == 0 {
+					return false, nil, nil
+				}
+				readErrors--
+				return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "configmaps"}, "lock", fmt.Errorf("denied"))
+			})
+
+			l := &lease{kube: e.kube, namespace: e.tillerNamespace, release: e.name, holder: "other", duration: time.Minute}
+			if test.renewedAgo > 0 {
+				_, err := e.kube.CoreV1().ConfigMaps(e.tillerNamespace).Create(&v1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: l.configMapName()},
+					Data:       l.data(time.Now().Add(-test.renewedAgo), time.Now().Add(-test.renewedAgo)),
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.releaseAfter > 0 {
+				go func() {
+					time.Sleep(test.releaseAfter)
+					l.unlock()
+				}()
+			}
+
+			err := e.run(context.Background())
+
+			status := 0
+			if err != nil {
+				status = exitStatus(err)
+			}
+			if client.rollbacks != test.expectedRollbacks || status != test.expectedStatus {
+				t.Errorf(
+					"\nexpected: %d rollback(s), exit status %d\ngot: %d rollback(s), exit status %d (%v)\n",
+					test.expectedRollbacks,
+					test.expectedStatus,
+					client.rollbacks,
+					status,
+					err,
+				)
+			}
+
+			cm, err := e.kube.CoreV1().ConfigMaps(e.tillerNamespace).Get(l.configMapName(), metav1.GetOptions{})
+			if test.expectedStatus == 0 && err == nil {
+				t.Errorf("expected the lock to be released, held by %s", cm.Data["holder"])
+			}
+		})
+	}
+}
+
+func TestLeaseRenew(t *testing.T) {
+	client := newFakeHelmClient("my-release")
+	e := newTestEngine(client, combinationRule{kind: ruleAny}, onDatasourceErrorAbort)
+
+	l := &lease{kube: e.kube, namespace: e.tillerNamespace, release: e.name, holder: "me", duration: time.Minute}
+	if err := l.acquire(time.Now(), false); err != nil {
+		t.Fatal(err)
+	}
+
+	other := &lease{kube: e.kube, namespace: e.tillerNamespace, release: e.name, holder: "other", duration: time.Minute}
+	if err := other.acquire(time.Now().Add(time.Hour), true); err != nil {
+		t.Fatal(err)
+	}
+
+	holder, err := l.renew(time.Now())
+	if err != nil || holder != "other" {
+		t.Errorf("expected the lock to be taken over by other, got holder %q, error %v", holder, err)
+	}
+}
+
+func TestLeaseRenewRecreated(t *testing.T) {
+	client := newFakeHelmClient("my-release")
+	e := newTestEngine(client, combinationRule{kind: ruleAny}, onDatasourceErrorAbort)
+
+	other := &lease{kube: e.kube, namespace: e.tillerNamespace, release: e.name, holder: "other", duration: time.Minute}
+	if err := other.acquire(time.Now(), false); err != nil {
+		t.Fatal(err)
+	}
+
+	// the lock was deleted when read by the renewal, then acquired by another
+	// monitor before being acquired again
+	deleted := true
+	e.kube.(*fake.Clientset).PrependReactor("get", "configmaps", func(action ktesting.Action) (bool, runtime.Object, error) {
+		if !deleted {
+			return false, nil, nil
+		}
+		deleted = false
+		return true, nil, apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, other.configMapName())
+	})
+
+	l := &lease{kube: e.kube, namespace: e.tillerNamespace, release: e.name, holder: "me", duration: time.Minute}
+	holder, err := l.renew(time.Now())
+	if err != nil || holder != "other" {
+		t.Errorf("expected the lock to be lost to other, got holder %q, error %v", holder, err)
+	}
+}
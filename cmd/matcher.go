@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// labelMatcher matches the labels of an alert, ie: severity=critical,
+// release="my-release", job=~"api|web" or env!="staging". Regular
+// expressions are anchored like in Prometheus.
+type labelMatcher struct {
+	name  string
+	op    string
+	value string
+	re    *regexp.Regexp
+
+	templates templates
+}
+
+var (
+	labelMatcherOperators = []string{"=~", "!~", "!=", "="}
+	labelNameRegexp       = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// parseLabelMatcher parses a matcher made of a label name, an operator and a
+// value, optionally quoted.
+func parseLabelMatcher(s string) (*labelMatcher, error) {
+	i := strings.IndexAny(s, "=!")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid matcher %q, expected a label name followed by =, !=, =~ or !~ and a value", s)
+	}
+
+	m := &labelMatcher{name: strings.TrimSpace(s[:i])}
+	if !labelNameRegexp.MatchString(m.name) {
+		return nil, fmt.Errorf("invalid matcher %q, %q is not a valid label name", s, m.name)
+	}
+
+	for _, op := range labelMatcherOperators {
+		if strings.HasPrefix(s[i:], op) {
+			m.op = op
+			m.value = strings.TrimSpace(s[i+len(op):])
+			break
+		}
+	}
+	if m.op == "" {
+		return nil, fmt.Errorf("invalid matcher %q, expected a label name followed by =, !=, =~ or !~ and a value", s)
+	}
+
+	if strings.HasPrefix(m.value, `"`) {
+		value, err := strconv.Unquote(m.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid matcher %q, the value is not properly quoted", s)
+		}
+		m.value = value
+	}
+
+	if err := m.compile(); err != nil {
+		return nil, fmt.Errorf("invalid matcher %q: %s", s, err)
+	}
+
+	return m, nil
+}
+
+// parseLabelMatchers parses a list of matchers.
+func parseLabelMatchers(list []string) ([]*labelMatcher, error) {
+	matchers := []*labelMatcher{}
+	for _, s := range list {
+		m, err := parseLabelMatcher(s)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+// compile compiles the regular expression of the matcher, if any.
+func (m *labelMatcher) compile() error {
+	if m.op != "=~" && m.op != "!~" {
+		return nil
+	}
+
+	re, err := regexp.Compile("^(?:" + m.value + ")$")
+	if err != nil {
+		return err
+	}
+	m.re = re
+	return nil
+}
+
+// render renders the template of the value of the matcher.
+func (m *labelMatcher) render(data *templateData) error {
+	if err := m.templates.render(data, "matcher "+m.name, &m.value); err != nil {
+		return err
+	}
+	return m.compile()
+}
+
+// matches returns true if the labels match, a missing label is empty.
+func (m *labelMatcher) matches(labels map[string]string) bool {
+	value := labels[m.name]
+
+	switch m.op {
+	case "=":
+		return value == m.value
+	case "!=":
+		return value != m.value
+	case "=~":
+		return m.re.MatchString(value)
+	case "!~":
+		return !m.re.MatchString(value)
+	}
+
+	return false
+}
+
+func (m *labelMatcher) String() string {
+	return fmt.Sprintf("%s%s%q", m.name, m.op, m.value)
+}
+
+// matchLabels returns true if the labels match all the matchers.
+func matchLabels(matchers []*labelMatcher, labels map[string]string) bool {
+	for _, m := range matchers {
+		if !m.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// formatLabels formats labels as a Prometheus label set, sorted by name.
+func formatLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=%q", name, labels[name])
+	}
+
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
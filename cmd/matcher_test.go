@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLabelMatcher(t *testing.T) {
+	labels := map[string]string{"release": "my-release", "severity": "critical"}
+
+	for _, test := range []struct {
+		name          string
+		input         string
+		expected      bool
+		expectedError bool
+	}{
+		{
+			name:     "it should match an equal quoted value",
+			input:    `release="my-release"`,
+			expected: true,
+		},
+		{
+			name:     "it should match an equal unquoted value",
+			input:    `severity=critical`,
+			expected: true,
+		},
+		{
+			name:     "it should not match a different value",
+			input:    `severity!=critical`,
+			expected: false,
+		},
+		{
+			name:     "it should match an anchored regular expression",
+			input:    `release=~"my-.*"`,
+			expected: true,
+		},
+		{
+			name:     "it should not match a partial regular expression",
+			input:    `release=~"my"`,
+			expected: false,
+		},
+		{
+			name:     "it should match a missing label as empty",
+			input:    `team!~".+"`,
+			expected: true,
+		},
+		{
+			name:          "it should reject a matcher without operator",
+			input:         `release`,
+			expectedError: true,
+		},
+		{
+			name:          "it should reject an invalid label name",
+			input:         `my-label=value`,
+			expectedError: true,
+		},
+		{
+			name:          "it should reject an invalid regular expression",
+			input:         `release=~"("`,
+			expectedError: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			m, err := parseLabelMatcher(test.input)
+			if (err != nil) != test.expectedError {
+				t.Fatalf("\ngiven %s\nexpected error: %v\ngot: %v\n", test.input, test.expectedError, err)
+			}
+			if err == nil && m.matches(labels) != test.expected {
+				t.Errorf("\ngiven %s\nexpected: %v\ngot: %v\n", test.input, test.expected, !test.expected)
+			}
+		})
+	}
+}
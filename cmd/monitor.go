@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc"
@@ -19,24 +20,63 @@ var (
 )
 
 type monitorCmd struct {
+	absent              bool
+	allowMajorRollback  bool
+	absentFor           int64
+	approval            string
+	approvalAddress     string
+	approvalDefault     string
+	approvalTimeout     int64
+	approvalToken       string
+	condition           string
+	consecutiveFailures int
 	disableHooks        bool
 	dryRun              bool
+	errorBudget         int
 	expectedResultCount int64
+	failureDuration     int64
 	force               bool
 	interval            int64
+	kubeContext         string
+	lockDuration        int64
+	maxRollbacks        int
+	minVolume           int64
+	onDatasourceError   string
+	onLocked            string
+	pauseAlertmanager   string
+	pauseHTTP           httpOptions
+	pauseLabel          string
+	readyTimeout        int64
+	remediation         string
+	remediationSet      []string
+	retries             int
+	retryBackoff        time.Duration
+	rollbackOnFailed    bool
 	rollbackTimeout     int64
+	rollbackTo          int32
+	rollbackWindow      int64
 	timeout             int64
+	verify              int64
 	wait                bool
+	warmUp              int64
+	webhookURL          string
+	windowFailures      int
+	windowSize          int
 }
 
 const monitorDesc = `
-This command monitor a release by querying Prometheus or Elasticsearch at a
-given interval and take care of rolling back to the previous version if the
-query return a non-empty result.
+This command monitor a release by querying Prometheus, Elasticsearch or Sentry
+at a given interval and take care of rolling back to the previous version if
+the query return a non-empty result. The monitor can also be described by a
+spec file, see the run subcommand.
 `
 
 func setupConnection(c *cobra.Command, args []string) error {
 	settings.TillerHost = os.Getenv("TILLER_HOST")
+	settings.TillerNamespace = os.Getenv("TILLER_NAMESPACE")
+	if settings.TillerNamespace == "" {
+		settings.TillerNamespace = "kube-system"
+	}
 	return nil
 }
 
@@ -66,34 +106,102 @@ func newMonitorCmd(out io.Writer) *cobra.Command {
 	monitor = &monitorCmd{}
 
 	cmd := &cobra.Command{
-		Use:   "monitor prometheus|elasticsearch",
+		Use:   "monitor prometheus|elasticsearch|sentry|run",
 		Short: "monitor a release",
 		Long:  monitorDesc,
 	}
 
 	p := cmd.PersistentFlags()
-	p.BoolVar(&monitor.disableHooks, "no-hooks", false, "prevent hooks from running during rollback")
-	p.BoolVar(&monitor.dryRun, "dry-run", false, "simulate a rollback if triggered by query result")
+	p.BoolVar(&monitor.disableHooks, "no-hooks", false, "prevent hooks from running during rollback or upgrade")
+	p.BoolVar(&monitor.dryRun, "dry-run", false, "simulate the remediation if triggered by query result")
+	p.StringVar(&monitor.remediation, "remediation", remediationRollback, "action applied once a failure is detected: rollback, scale-down (scale the release deployments and stateful sets to 0), upgrade-with-values (upgrade the release with --remediation-set values), webhook (post the failure to --webhook-url) or notify-only")
+	p.StringArrayVar(&monitor.remediationSet, "remediation-set", []string{}, "value set on the release by the upgrade-with-values remediation, ie: safeMode=true (can be repeated)")
+	p.StringVar(&monitor.webhookURL, "webhook-url", "", "URL the webhook remediation posts the failure to")
+	p.StringVar(&monitor.pauseAlertmanager, "pause-alertmanager", "", "address of an Alertmanager whose active silences matching the release on --pause-label pause the monitoring, ie: http://alertmanager:9093")
+	p.StringVar(&monitor.pauseLabel, "pause-label", "release", "label of the Alertmanager silences matched against the release name")
+	monitor.pauseHTTP.addPrefixedFlags(p, "pause")
+	p.StringVar(&monitor.onLocked, "on-locked", onLockedFail, "behavior when another monitor holds the lock of the release: fail, wait (until the lock is released or stale) or take-over (a stale lock, fail otherwise)")
+	p.Int64Var(&monitor.lockDuration, "lock-duration", 60, "time in seconds after which a lock which wasn't renewed by its monitor is stale")
+	p.StringVar(&monitor.approval, "approval", "", "wait for a human to approve the remediation: tty (answer on the terminal) or http (POST to the /approve or /reject endpoint of --approval-address)")
+	p.Int64Var(&monitor.approvalTimeout, "approval-timeout", 600, "time in seconds to wait for an approval before applying --approval-default")
+	p.StringVar(&monitor.approvalDefault, "approval-default", approvalDefaultAbort, "decision once --approval-timeout is reached: rollback (apply the remediation) or abort")
+	p.StringVar(&monitor.approvalAddress, "approval-address", "127.0.0.1:8081", "address the http approval endpoints listen on, ie: :8081 to listen on every interface")
+	p.StringVar(&monitor.approvalToken, "approval-token", os.Getenv("HELM_MONITOR_APPROVAL_TOKEN"), "bearer token required by the http approval endpoints, defaults to $HELM_MONITOR_APPROVAL_TOKEN")
 	p.Int64Var(&monitor.expectedResultCount, "expected-result-count", 0, "number of results that are expected to be returned by the query (rollback triggered if the number of results exceeds this value)")
+	p.StringVar(&monitor.condition, "condition", "", "condition triggering a rollback, overrides --expected-result-count, ie: '< 10', '>= 5', '!= 0', 'in 10..20', 'not in 10..20' or '< -20%' for a change relative to the value at the start of the monitoring")
 	p.BoolVar(&monitor.force, "force", false, "force resource update through delete/recreate if needed")
-	p.BoolVar(&monitor.wait, "wait", false, "if set, will wait until all Pods, PVCs, Services, and minimum number of Pods of a Deployment are in a ready state before marking a rollback as successful. It will wait for as long as --rollback-timeout")
+	p.BoolVar(&monitor.wait, "wait", false, "if set, will wait until all Pods, PVCs, Services, and minimum number of Pods of a Deployment are in a ready state before marking a rollback or upgrade as successful. It will wait for as long as --rollback-timeout")
 	p.BoolVarP(&verbose, "verbose", "v", false, "enable verbose output")
-	p.Int64Var(&monitor.rollbackTimeout, "rollback-timeout", 300, "time in seconds to wait for any individual Kubernetes operation during the rollback (like Jobs for hooks)")
+	p.Int32Var(&monitor.rollbackTo, "rollback-to", 0, "revision to rollback to, by default the last revision marked as known-good by a successful monitoring or the previous revision")
+	p.IntVar(&monitor.maxRollbacks, "max-rollbacks", 3, "maximum number of automatic rollbacks of the release within --max-rollbacks-window, further rollbacks are refused (disabled if 0)")
+	p.Int64Var(&monitor.rollbackWindow, "max-rollbacks-window", 3600, "time window in seconds used by --max-rollbacks")
+	p.BoolVar(&monitor.allowMajorRollback, "allow-major-rollback", false, "allow rolling back to a revision using another major version of the chart")
+	p.Int64Var(&monitor.verify, "verify", 0, "time in seconds during which the checks are evaluated again after a rollback to verify that the system recovered (disabled if 0)")
+	p.Int64Var(&monitor.rollbackTimeout, "rollback-timeout", 300, "time in seconds to wait for any individual Kubernetes operation during the rollback or upgrade (like Jobs for hooks)")
 	p.Int64Var(&monitor.timeout, "timeout", 300, "time in seconds to wait before assuming a monitoring action is successfull")
 	p.Int64VarP(&monitor.interval, "interval", "i", 10, "time in seconds between each query")
+	p.Int64Var(&monitor.readyTimeout, "ready-timeout", 300, "time in seconds to wait for the release to be deployed and its workloads to be ready before monitoring (disabled if 0)")
+	p.Int64Var(&monitor.warmUp, "warm-up", 0, "time in seconds after the release is ready during which query results are recorded but never trigger a rollback")
+	p.BoolVar(&monitor.rollbackOnFailed, "rollback-on-failed-release", false, "rollback if the release is in FAILED status while waiting for it to be ready")
+	p.StringVar(&monitor.kubeContext, "kube-context", "", "name of the kubeconfig context used to check the readiness of the release workloads")
+	p.BoolVar(&monitor.absent, "absent", false, "consider the absence of data returned by the query (no series, no documents, no events) as a failure")
+	p.Int64Var(&monitor.absentFor, "absent-for", 0, "time in seconds the query must return no data before being considered as a failure, used with --absent")
+	p.Int64Var(&monitor.minVolume, "min-volume", 0, "minimum traffic volume measured by the volume query required to judge the release, queries below this volume are considered as insufficient data")
+	p.IntVar(&monitor.retries, "retries", 2, "number of times a failed query is retried, with an exponential backoff")
+	p.DurationVar(&monitor.retryBackoff, "retry-backoff", 500*time.Millisecond, "initial time to wait before retrying a failed query, doubled at each attempt with a random jitter")
+	p.IntVar(&monitor.errorBudget, "error-budget", 0, "number of queries failing after retries tolerated during the monitoring before applying --on-datasource-error")
+	p.StringVar(&monitor.onDatasourceError, "on-datasource-error", onDatasourceErrorKeep, "action once the error budget is exhausted: keep (keep monitoring), abort (stop without rollback) or rollback")
+	p.IntVar(&monitor.consecutiveFailures, "consecutive-failures", 1, "number of consecutive failing queries required to rollback")
+	p.IntVar(&monitor.windowSize, "window-size", 0, "number of queries in the sliding window used by --window-failures (disabled if 0)")
+	p.IntVar(&monitor.windowFailures, "window-failures", 0, "number of failing queries within the last --window-size queries required to rollback")
+	p.Int64Var(&monitor.failureDuration, "failure-duration", 0, "time in seconds the queries must be failing continuously before rolling back")
 
 	cmd.AddCommand(
 		newMonitorPrometheusCmd(out),
+		newMonitorPrometheusAlertsCmd(out),
+		newMonitorAlertmanagerCmd(out),
 		newMonitorElasticsearchCmd(out),
 		newMonitorSentryCmd(out),
+		newMonitorRunCmd(out),
 	)
 
 	return cmd
 }
 
+// Exit statuses of the command, distinguishing the outcomes of the monitoring
+// which are not a failure of the command itself.
+const (
+	exitFailure         = 1
+	exitReleaseChanged  = 2
+	exitRollbackBlocked = 3
+	exitNotRecovered    = 4
+	exitNotApproved     = 5
+	exitLocked          = 6
+)
+
+// exitStatus returns the exit status of the command from its error.
+func exitStatus(err error) int {
+	if isReleaseChanged(err) {
+		return exitReleaseChanged
+	}
+	if isRollbackBlocked(err) {
+		return exitRollbackBlocked
+	}
+	if isNotRecovered(err) {
+		return exitNotRecovered
+	}
+	if isNotApproved(err) {
+		return exitNotApproved
+	}
+	if isLocked(err) {
+		return exitLocked
+	}
+	return exitFailure
+}
+
 func main() {
 	cmd := newMonitorCmd(os.Stdout)
 	if err := cmd.Execute(); err != nil {
-		os.Exit(1)
+		os.Exit(exitStatus(err))
 	}
 }
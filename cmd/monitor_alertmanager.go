@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/helm/pkg/helm"
+)
+
+const monitorAlertmanagerDesc = `
+This command monitor a release by polling the alerts of an Alertmanager at a
+given interval and take care of rolling back to the previous version if the
+number of active alerts matching the given label matchers, neither silenced nor
+inhibited, is greater than 0 (or --expected-result-count).
+
+Example:
+
+  $ helm monitor alertmanager my-release \
+      --matcher 'release="{{ .Release.Name }}"' \
+      --matcher severity=critical
+
+
+Reference:
+
+  https://github.com/prometheus/alertmanager/blob/master/api/v2/openapi.yaml
+
+`
+
+const defaultAlertmanagerAddr = "http://localhost:9093"
+
+type monitorAlertmanagerCmd struct {
+	name     string
+	out      io.Writer
+	client   helm.Interface
+	provider *alertmanagerProvider
+	matchers []string
+	http     httpOptions
+}
+
+// alertmanagerProvider counts the active alerts of an Alertmanager matching a
+// set of label matchers, silenced and inhibited alerts are ignored.
+type alertmanagerProvider struct {
+	addr       string
+	matchers   []*labelMatcher
+	templates  templates
+	httpClient *http.Client
+}
+
+type alertmanagerAlert struct {
+	Labels   map[string]string `json:"labels"`
+	StartsAt time.Time         `json:"startsAt"`
+	Status   struct {
+		State       string   `json:"state"`
+		SilencedBy  []string `json:"silencedBy"`
+		InhibitedBy []string `json:"inhibitedBy"`
+	} `json:"status"`
+}
+
+func (a *alertmanagerAlert) String() string {
+	return fmt.Sprintf("%s%s active since %s", a.Labels["alertname"], formatLabels(a.Labels), a.StartsAt.Local().Format("15:04:05"))
+}
+
+func newMonitorAlertmanagerCmd(out io.Writer) *cobra.Command {
+	m := &monitorAlertmanagerCmd{
+		out:      out,
+		provider: newAlertmanagerProvider(),
+	}
+
+	cmd := &cobra.Command{
+		Use:     "alertmanager [flags] RELEASE",
+		Short:   "watch the alerts of an alertmanager",
+		Long:    monitorAlertmanagerDesc,
+		PreRunE: setupConnection,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("This command needs 1 argument: release name")
+			}
+
+			m.name = args[0]
+			m.client = ensureHelmClient(m.client)
+
+			return m.run()
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&m.provider.addr, "alertmanager", defaultAlertmanagerAddr, "alertmanager address")
+	f.StringArrayVar(&m.matchers, "matcher", []string{}, "label matcher of the alerts, ie: --matcher 'release=\"{{ .Release.Name }}\"' --matcher severity=critical, also accepts !=, =~ and !~")
+	m.http.addFlags(f)
+
+	cmd.MarkFlagRequired("matcher")
+
+	return cmd
+}
+
+func (m *monitorAlertmanagerCmd) run() error {
+	matchers, err := parseLabelMatchers(m.matchers)
+	if err != nil {
+		return err
+	}
+	m.provider.matchers = matchers
+
+	m.provider.httpClient, err = m.http.client(5 * time.Second)
+	if err != nil {
+		return err
+	}
+
+	c, err := newCheck("alertmanager", m.provider)
+	if err != nil {
+		return err
+	}
+
+	return newEngine(m.name, m.out, m.client, c).run(context.Background())
+}
+
+func newAlertmanagerProvider() *alertmanagerProvider {
+	return &alertmanagerProvider{
+		addr:       defaultAlertmanagerAddr,
+		matchers:   []*labelMatcher{},
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// render implements the templatedProvider interface.
+func (p *alertmanagerProvider) render(data *templateData) error {
+	if err := p.templates.render(data, "address", &p.addr); err != nil {
+		return err
+	}
+	for _, m := range p.matchers {
+		if err := m.render(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query implements the Provider interface by listing the active alerts of the
+// Alertmanager v2 API and counting the ones matching the matchers. The alerts
+// are filtered by the API and again by the provider.
+func (p *alertmanagerProvider) Query(ctx context.Context) (*Result, error) {
+	req, err := http.NewRequest("GET", p.addr+"/api/v2/alerts", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	q := url.Values{
+		"active":      {"true"},
+		"silenced":    {"false"},
+		"inhibited":   {"false"},
+		"unprocessed": {"false"},
+	}
+	for _, m := range p.matchers {
+		q.Add("filter", m.String())
+	}
+	req.URL.RawQuery = q.Encode()
+
+	debug("Processing URL %s", req.URL.Redacted())
+
+	res, err := p.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	debug("Response: %s", body)
+
+	// a bad request is an invalid filter, the error is a JSON string
+	if res.StatusCode == http.StatusBadRequest {
+		var message string
+		if json.Unmarshal(body, &message) != nil {
+			message = string(body)
+		}
+		return nil, newQueryError("%s", message)
+	}
+	if res.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	alerts := []*alertmanagerAlert{}
+	if err := json.Unmarshal(body, &alerts); err != nil {
+		return nil, fmt.Errorf("invalid response: %s", err)
+	}
+
+	result := &Result{}
+	for _, alert := range alerts {
+		if !p.matches(alert) {
+			continue
+		}
+		result.Count++
+		result.Breaching = append(result.Breaching, alert.String())
+	}
+	result.Value = float64(result.Count)
+
+	return result, nil
+}
+
+// matches returns true if the alert is active, neither silenced nor inhibited,
+// and matches the matchers.
+func (p *alertmanagerProvider) matches(alert *alertmanagerAlert) bool {
+	if alert.Status.State != "active" || len(alert.Status.SilencedBy) > 0 || len(alert.Status.InhibitedBy) > 0 {
+		return false
+	}
+	return matchLabels(p.matchers, alert.Labels)
+}
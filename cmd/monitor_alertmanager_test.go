@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newAlertmanagerStub returns an Alertmanager replying with the given alerts,
+// it requires the given bearer token if any.
+func newAlertmanagerStub(token string, alerts string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/alerts" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if q := r.URL.Query(); q.Get("active") != "true" || q.Get("silenced") != "false" || q.Get("inhibited") != "false" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `"unexpected filters"`)
+			return
+		}
+		fmt.Fprint(w, alerts)
+	}))
+}
+
+func TestAlertmanagerProviderQuery(t *testing.T) {
+	for _, test := range []struct {
+		name               string
+		alerts             string
+		expectedCount      int64
+		expectedQueryError bool
+	}{
+		{
+			name:          "it should count the active alerts matching the matchers",
+			alerts:        `[{"labels":{"alertname":"HighErrorRate","release":"my-release"},"status":{"state":"active"}},{"labels":{"alertname":"HighErrorRate","release":"other"},"status":{"state":"active"}}]`,
+			expectedCount: 1,
+		},
+		{
+			name:   "it should ignore the silenced alerts",
+			alerts: `[{"labels":{"alertname":"HighErrorRate","release":"my-release"},"status":{"state":"suppressed","silencedBy":["1"]}}]`,
+		},
+		{
+			name:   "it should ignore the inhibited alerts",
+			alerts: `[{"labels":{"alertname":"HighErrorRate","release":"my-release"},"status":{"state":"active","inhibitedBy":["abc"]}}]`,
+		},
+		{
+			name:   "it should ignore the unprocessed alerts",
+			alerts: `[{"labels":{"alertname":"HighErrorRate","release":"my-release"},"status":{"state":"unprocessed"}}]`,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			server := newAlertmanagerStub("", test.alerts)
+			defer server.Close()
+
+			p := newAlertmanagerProvider()
+			p.addr = server.URL
+			p.matchers, _ = parseLabelMatchers([]string{`release="my-release"`})
+
+			result, err := p.Query(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Count != test.expectedCount || len(result.Breaching) != int(test.expectedCount) {
+				t.Errorf("\ngiven %s\nexpected: %d alert(s)\ngot: %d alert(s), %v\n", test.alerts, test.expectedCount, result.Count, result.Breaching)
+			}
+		})
+	}
+
+	t.Run("it should return a query error on an invalid filter", func(t *testing.T) {
+		server := newTestServer(http.StatusBadRequest, `"bad matcher format: release"`)
+		defer server.Close()
+
+		p := newAlertmanagerProvider()
+		p.addr = server.URL
+
+		_, err := p.Query(context.Background())
+		if !isQueryError(err) {
+			t.Errorf("expected a query error, got %v", err)
+		}
+	})
+}
+
+func TestEngineAlertmanager(t *testing.T) {
+	server := newAlertmanagerStub("secret", `[{"labels":{"alertname":"HighErrorRate","release":"my-release"},"status":{"state":"active"}}]`)
+	defer server.Close()
+
+	p := newAlertmanagerProvider()
+	p.addr = server.URL
+	p.matchers, _ = parseLabelMatchers([]string{`release="{{ .Release.Name }}"`})
+
+	var err error
+	p.httpClient, err = (&httpOptions{bearerToken: "secret"}).client(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newFakeHelmClient("my-release")
+	e := newTestEngine(client, combinationRule{kind: ruleAny}, onDatasourceErrorAbort)
+	e.checks = []*check{{
+		name:      "alertmanager",
+		provider:  p,
+		condition: newCountCondition(0),
+		policy:    failurePolicy{consecutiveFailures: 1},
+	}}
+	// the stub is queried within an interval
+	e.interval = 10 * time.Millisecond
+	e.timeout = 200 * time.Millisecond
+
+	err = e.run(context.Background())
+	if err != nil || client.rollbacks != 1 {
+		t.Errorf("expected 1 rollback, got %d rollback(s), error %v", client.rollbacks, err)
+	}
+}
@@ -1,14 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
-	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -38,21 +37,65 @@ Reference:
 
 `
 
+const defaultElasticsearchAddr = "http://localhost:9200"
+
 type monitorElasticsearchCmd struct {
-	name              string
-	out               io.Writer
-	client            helm.Interface
-	elasticsearchAddr string
-	query             string
+	name        string
+	out         io.Writer
+	client      helm.Interface
+	provider    *elasticsearchProvider
+	volumeQuery string
+}
+
+// elasticsearchProvider runs a count query, either from a Lucene query
+// string or a query DSL file, and returns the number of matching documents.
+type elasticsearchProvider struct {
+	addr      string
+	query     string
+	queryBody []byte
+
+	// rawQueryBody is the content of the query DSL file before its rendering
+	rawQueryBody []byte
+	templates    templates
+	httpClient   *http.Client
 }
 
 type elasticsearchQueryResponse struct {
-	Count int64 `json:"count"`
+	Count  *int64      `json:"count"`
+	Error  interface{} `json:"error"`
+	Status int         `json:"status"`
+}
+
+// validate checks that the response isn't an error document and contains a
+// count. Bad requests are errors of the query itself, e.g. a parsing exception,
+// while any other error is an error of the datasource.
+func (r *elasticsearchQueryResponse) validate(res *http.Response) error {
+	if r.Error != nil {
+		message := fmt.Sprintf("%v", r.Error)
+		if e, ok := r.Error.(map[string]interface{}); ok {
+			message = fmt.Sprintf("%v: %v", e["type"], e["reason"])
+		}
+		if res.StatusCode == http.StatusBadRequest {
+			return newQueryError("%s", message)
+		}
+		return fmt.Errorf("%s", message)
+	}
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	if r.Count == nil {
+		return fmt.Errorf("invalid response: missing count")
+	}
+
+	return nil
 }
 
 func newMonitorElasticsearchCmd(out io.Writer) *cobra.Command {
 	m := &monitorElasticsearchCmd{
-		out: out,
+		out:      out,
+		provider: newElasticsearchProvider(),
 	}
 
 	cmd := &cobra.Command{
@@ -62,11 +105,11 @@ func newMonitorElasticsearchCmd(out io.Writer) *cobra.Command {
 		PreRunE: setupConnection,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) != 2 {
-				return fmt.Errorf("This command neeeds 2 argument: release name, query DSL path or Lucene query")
+				return fmt.Errorf("This command needs 2 arguments: release name, query DSL path or Lucene query")
 			}
 
 			m.name = args[0]
-			m.query = args[1]
+			m.provider.setQuery(args[1])
 			m.client = ensureHelmClient(m.client)
 
 			return m.run()
@@ -74,108 +117,125 @@ func newMonitorElasticsearchCmd(out io.Writer) *cobra.Command {
 	}
 
 	f := cmd.Flags()
-	f.StringVar(&m.elasticsearchAddr, "elasticsearch", "http://localhost:9200", "elasticsearch address")
+	f.StringVar(&m.provider.addr, "elasticsearch", defaultElasticsearchAddr, "elasticsearch address")
+	f.StringVar(&m.volumeQuery, "volume-query", "", "query DSL path or Lucene query measuring the traffic volume, the document count is compared to --min-volume")
 
 	return cmd
 }
 
 func (m *monitorElasticsearchCmd) run() error {
-	_, err := m.client.ReleaseContent(m.name)
+	c, err := newCheck("elasticsearch", m.provider)
 	if err != nil {
-		return prettyError(err)
+		return err
 	}
 
-	fmt.Fprintf(m.out, "Monitoring %s...\n", m.name)
+	if m.volumeQuery != "" {
+		volume := *m.provider
+		volume.setQuery(m.volumeQuery)
+		c.volume = &volume
+	}
+
+	return newEngine(m.name, m.out, m.client, c).run(context.Background())
+}
+
+func newElasticsearchProvider() *elasticsearchProvider {
+	return &elasticsearchProvider{
+		addr:       defaultElasticsearchAddr,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// setQuery sets the query, which is either the path of a query DSL json file
+// or a Lucene query string. The file is read once as its content is sent with
+// every request.
+func (p *elasticsearchProvider) setQuery(query string) {
+	p.query = query
+	p.queryBody = nil
 
-	client := &http.Client{Timeout: 10 * time.Second}
+	queryBody, err := ioutil.ReadFile(query)
+	if err == nil {
+		p.queryBody = queryBody
+	}
+}
+
+// render implements the templatedProvider interface, the content of a query
+// DSL file is rendered instead of its path.
+func (p *elasticsearchProvider) render(data *templateData) error {
+	if err := p.templates.render(data, "address", &p.addr); err != nil {
+		return err
+	}
+
+	if p.queryBody == nil {
+		return p.templates.render(data, "query", &p.query)
+	}
 
-	queryBody, err := os.Open(m.query)
+	if p.rawQueryBody == nil {
+		p.rawQueryBody = p.queryBody
+	}
+	body := string(p.rawQueryBody)
+	if err := renderTemplate(data, p.query, &body); err != nil {
+		return err
+	}
+	p.queryBody = []byte(body)
+	return nil
+}
 
+// Query implements the Provider interface by running a count query against
+// the Elasticsearch API.
+func (p *elasticsearchProvider) Query(ctx context.Context) (*Result, error) {
 	var req *http.Request
-	if err != nil {
-		req, err = http.NewRequest("GET", m.elasticsearchAddr+"/_count", nil)
+	var err error
+	if p.queryBody == nil {
+		req, err = http.NewRequest("GET", p.addr+"/_count", nil)
 		if err != nil {
-			return prettyError(err)
+			return nil, err
 		}
 
 		q := req.URL.Query()
-		q.Add("q", m.query)
+		q.Add("q", p.query)
 		req.URL.RawQuery = q.Encode()
 	} else {
-		req, err = http.NewRequest("GET", m.elasticsearchAddr+"/_count", queryBody)
+		req, err = http.NewRequest("GET", p.addr+"/_count", bytes.NewReader(p.queryBody))
 		if err != nil {
-			return prettyError(err)
+			return nil, err
 		}
 		req.Header.Set("Content-Type", "application/json")
 	}
 
-	quit := make(chan os.Signal)
-	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
-
-	ticker := time.NewTicker(time.Second * time.Duration(monitor.interval))
-
-	go func() {
-		time.Sleep(time.Second * time.Duration(monitor.timeout))
-		fmt.Fprintf(m.out, "No results after %d second(s)\n", monitor.timeout)
-		close(quit)
-	}()
-
-	for {
-		select {
-		case <-ticker.C:
-			debug("Processing URL %s", req.URL.String())
-
-			res, err := client.Do(req)
-			if err != nil {
-				return prettyError(err)
-			}
-
-			defer res.Body.Close()
-
-			body, err := ioutil.ReadAll(res.Body)
-
-			if err != nil {
-				return prettyError(err)
-			}
-
-			fmt.Printf("Body: %s\n", string(body))
-
-			response := &elasticsearchQueryResponse{}
-			err = json.Unmarshal(body, response)
-			if err != nil {
-				return prettyError(err)
-			}
+	debug("Processing URL %s", req.URL.Redacted())
 
-			debug("Response: %v", response)
-			debug("Result count: %d", response.Count)
+	res, err := p.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
 
-			if response.Count > monitor.expectedResultCount {
-				ticker.Stop()
+	defer res.Body.Close()
 
-				fmt.Fprintf(m.out, "Failure detected, rolling back...\n")
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
 
-				_, err := m.client.RollbackRelease(
-					m.name,
-					helm.RollbackDryRun(monitor.dryRun),
-					helm.RollbackRecreate(false),
-					helm.RollbackForce(monitor.force),
-					helm.RollbackDisableHooks(monitor.disableHooks),
-					helm.RollbackVersion(0),
-					helm.RollbackTimeout(monitor.rollbackTimeout),
-					helm.RollbackWait(monitor.wait))
+	debug("Body: %s", string(body))
 
-				if err != nil {
-					return prettyError(err)
-				}
+	response := &elasticsearchQueryResponse{}
+	err = json.Unmarshal(body, response)
+	if err != nil {
+		if res.StatusCode/100 != 2 {
+			return nil, fmt.Errorf("unexpected status %s", res.Status)
+		}
+		return nil, fmt.Errorf("invalid response: %s", err)
+	}
 
-				fmt.Fprintf(m.out, "Successfully rolled back to previous revision!\n")
-				return nil
-			}
+	debug("Response: %v", response)
 
-		case <-quit:
-			ticker.Stop()
-			debug("Quitting...")
-			return nil
-		}
+	if err := response.validate(res); err != nil {
+		return nil, err
 	}
+
+	return &Result{
+		Count:  *response.Count,
+		Value:  float64(*response.Count),
+		NoData: *response.Count == 0,
+	}, nil
 }
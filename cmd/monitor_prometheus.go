@@ -1,14 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"net/http"
-	"os"
-	"os/signal"
-	"syscall"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -24,6 +25,17 @@ Example:
 
   $ helm monitor prometheus my-release 'rate(http_requests_total{code=~"^5.*$"}[5m]) > 0'
 
+Example comparing the sample value of every series to a threshold:
+
+  $ helm monitor prometheus --threshold '> 0.01' my-release \
+      'sum by (job) (rate(http_requests_total{code=~"^5.*$"}[5m])) / sum by (job) (rate(http_requests_total[5m]))'
+
+Example failing when the error ratio is above 2% continuously for 3 minutes,
+evaluated over the last 10 minutes:
+
+  $ helm monitor prometheus --threshold '> 0.02' --for 180 --range 600 my-release \
+      'sum(rate(http_requests_total{code=~"^5.*$"}[1m])) / sum(rate(http_requests_total[1m]))'
+
 
 Reference:
 
@@ -31,23 +43,189 @@ Reference:
 
 `
 
+const defaultPrometheusAddr = "http://localhost:9090"
+
 type monitorPrometheusCmd struct {
-	name           string
-	out            io.Writer
-	client         helm.Interface
-	prometheusAddr string
-	query          string
+	name        string
+	out         io.Writer
+	client      helm.Interface
+	provider    *prometheusProvider
+	threshold   string
+	rangeWindow int64
+	forDuration int64
+	step        int64
+	volumeQuery string
+	http        httpOptions
+}
+
+// prometheusProvider runs a PromQL instant query and counts the returned
+// series, or the series whose sample value matches the threshold if any.
+type prometheusProvider struct {
+	addr      string
+	query     string
+	threshold *condition
+
+	// rangeWindow enables the evaluation of a range query over the given
+	// lookback window, with the samples evaluated at every step and series
+	// firing once breaching for forDuration
+	rangeWindow time.Duration
+	forDuration time.Duration
+	step        time.Duration
+
+	templates  templates
+	httpClient *http.Client
 }
 
 type prometheusQueryResponse struct {
-	Data struct {
-		Result []struct{} `json:"result"`
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
 	} `json:"data"`
 }
 
+// prometheusSeries is a series of a result with its latest sample value, and
+// all its samples for a matrix. A scalar or string result is a single series
+// without labels.
+type prometheusSeries struct {
+	labels  map[string]string
+	value   float64
+	samples []prometheusSample
+}
+
+type prometheusSample struct {
+	time  time.Time
+	value float64
+}
+
+func (s prometheusSeries) String() string {
+	return fmt.Sprintf("%s %g", formatLabels(s.labels), s.value)
+}
+
+// parseThreshold parses the threshold compared to the sample value of every
+// series. A relative threshold would need a baseline per series, it isn't
+// supported.
+func parseThreshold(s string) (*condition, error) {
+	threshold, err := parseCondition(s)
+	if err != nil {
+		return nil, err
+	}
+	if threshold.relative {
+		return nil, fmt.Errorf("invalid threshold %q, relative thresholds are not supported", s)
+	}
+	return threshold, nil
+}
+
+// validate checks the status of the response and the type of the result. Bad
+// data and execution errors are errors of the query itself, while timeouts or
+// unavailability are errors of the datasource.
+func (r *prometheusQueryResponse) validate(res *http.Response) error {
+	if r.Status == "error" {
+		switch r.ErrorType {
+		case "bad_data", "execution":
+			return newQueryError("%s: %s", r.ErrorType, r.Error)
+		}
+		return fmt.Errorf("%s: %s", r.ErrorType, r.Error)
+	}
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	if r.Status != "success" {
+		return fmt.Errorf("unexpected response status %q", r.Status)
+	}
+
+	switch r.Data.ResultType {
+	case "vector", "matrix", "scalar", "string":
+		return nil
+	}
+
+	return newQueryError("unexpected result type %q", r.Data.ResultType)
+}
+
+// series returns the series of the result with their latest sample value.
+func (r *prometheusQueryResponse) series() ([]prometheusSeries, error) {
+	series := []prometheusSeries{}
+
+	switch r.Data.ResultType {
+	case "vector":
+		result := []struct {
+			Metric map[string]string `json:"metric"`
+			Value  []interface{}     `json:"value"`
+		}{}
+		if err := json.Unmarshal(r.Data.Result, &result); err != nil {
+			return nil, fmt.Errorf("invalid vector result: %s", err)
+		}
+		for _, s := range result {
+			series = append(series, prometheusSeries{labels: s.Metric, value: sampleValue(s.Value)})
+		}
+	case "matrix":
+		result := []struct {
+			Metric map[string]string `json:"metric"`
+			Values [][]interface{}   `json:"values"`
+		}{}
+		if err := json.Unmarshal(r.Data.Result, &result); err != nil {
+			return nil, fmt.Errorf("invalid matrix result: %s", err)
+		}
+		for _, s := range result {
+			if len(s.Values) == 0 {
+				continue
+			}
+			samples := make([]prometheusSample, len(s.Values))
+			for i, v := range s.Values {
+				samples[i] = prometheusSample{time: sampleTime(v), value: sampleValue(v)}
+			}
+			series = append(series, prometheusSeries{labels: s.Metric, value: samples[len(samples)-1].value, samples: samples})
+		}
+	case "scalar", "string":
+		sample := []interface{}{}
+		if err := json.Unmarshal(r.Data.Result, &sample); err != nil {
+			return nil, fmt.Errorf("invalid %s result: %s", r.Data.ResultType, err)
+		}
+		series = append(series, prometheusSeries{value: sampleValue(sample)})
+	}
+
+	return series, nil
+}
+
+// sampleValue returns the value of a sample encoded as a
+// [<unix time>, "<value>"] pair, NaN if it isn't a number.
+func sampleValue(sample []interface{}) float64 {
+	if len(sample) != 2 {
+		return math.NaN()
+	}
+	s, ok := sample[1].(string)
+	if !ok {
+		return math.NaN()
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return v
+}
+
+// sampleTime returns the time of a sample encoded as a
+// [<unix time>, "<value>"] pair.
+func sampleTime(sample []interface{}) time.Time {
+	if len(sample) != 2 {
+		return time.Time{}
+	}
+	t, ok := sample[0].(float64)
+	if !ok {
+		return time.Time{}
+	}
+	sec, frac := math.Modf(t)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
+
 func newMonitorPrometheusCmd(out io.Writer) *cobra.Command {
 	m := &monitorPrometheusCmd{
-		out: out,
+		out:      out,
+		provider: newPrometheusProvider(),
 	}
 
 	cmd := &cobra.Command{
@@ -57,11 +235,11 @@ func newMonitorPrometheusCmd(out io.Writer) *cobra.Command {
 		PreRunE: setupConnection,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) != 2 {
-				return fmt.Errorf("This command neeeds 2 argument: release name, promql")
+				return fmt.Errorf("This command needs 2 arguments: release name, promql")
 			}
 
 			m.name = args[0]
-			m.query = args[1]
+			m.provider.query = args[1]
 			m.client = ensureHelmClient(m.client)
 
 			return m.run()
@@ -69,95 +247,167 @@ func newMonitorPrometheusCmd(out io.Writer) *cobra.Command {
 	}
 
 	f := cmd.Flags()
-	f.StringVar(&m.prometheusAddr, "prometheus", "http://localhost:9090", "prometheus address")
+	f.StringVar(&m.provider.addr, "prometheus", defaultPrometheusAddr, "prometheus address")
+	f.StringVar(&m.threshold, "threshold", "", "condition compared to the sample value of every series, ie: '> 0.01', the result count is then the number of breaching series")
+	f.Int64Var(&m.rangeWindow, "range", 0, "evaluate a range query over the given number of seconds, defaults to --for plus one --step if --for is set")
+	f.Int64Var(&m.forDuration, "for", 0, "number of seconds a series must breach continuously in the range query before failing, like the for clause of an alerting rule")
+	f.Int64Var(&m.step, "step", 15, "resolution of the range query in seconds")
+	m.http.addFlags(f)
+	f.StringVar(&m.volumeQuery, "volume-query", "", "promql measuring the traffic volume, the sum of the sample values is compared to --min-volume")
 
 	return cmd
 }
 
 func (m *monitorPrometheusCmd) run() error {
-	_, err := m.client.ReleaseContent(m.name)
-	if err != nil {
-		return prettyError(err)
+	// the threshold and the range query count the breaching series, which is
+	// compared to the expected result count
+	if monitor.condition != "" && (m.threshold != "" || m.rangeWindow > 0 || m.forDuration > 0) {
+		return fmt.Errorf("--condition can't be used with --threshold, --range or --for, use --expected-result-count instead")
+	}
+
+	if m.threshold != "" {
+		threshold, err := parseThreshold(m.threshold)
+		if err != nil {
+			return err
+		}
+		m.provider.threshold = threshold
 	}
 
-	fmt.Fprintf(m.out, "Monitoring %s...\n", m.name)
+	client, err := m.http.client(5 * time.Second)
+	if err != nil {
+		return err
+	}
+	m.provider.httpClient = client
 
-	client := &http.Client{Timeout: 5 * time.Second}
+	m.provider.rangeWindow = time.Duration(m.rangeWindow) * time.Second
+	m.provider.forDuration = time.Duration(m.forDuration) * time.Second
+	m.provider.step = time.Duration(m.step) * time.Second
+	if err := m.provider.validateRange(); err != nil {
+		return err
+	}
 
-	req, err := http.NewRequest("GET", m.prometheusAddr+"/api/v1/query", nil)
+	c, err := newCheck("prometheus", m.provider)
 	if err != nil {
-		return prettyError(err)
+		return err
 	}
 
-	q := req.URL.Query()
-	q.Add("query", m.query)
-	req.URL.RawQuery = q.Encode()
+	if m.volumeQuery != "" {
+		volume := *m.provider
+		volume.query = m.volumeQuery
+		volume.threshold = nil
+		volume.rangeWindow = 0
+		volume.forDuration = 0
+		c.volume = &volume
+	}
 
-	quit := make(chan os.Signal)
-	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
+	return newEngine(m.name, m.out, m.client, c).run(context.Background())
+}
 
-	ticker := time.NewTicker(time.Second * time.Duration(monitor.interval))
+func newPrometheusProvider() *prometheusProvider {
+	return &prometheusProvider{
+		addr:       defaultPrometheusAddr,
+		step:       15 * time.Second,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
 
-	go func() {
-		time.Sleep(time.Second * time.Duration(monitor.timeout))
-		fmt.Fprintf(m.out, "No results after %d second(s)\n", monitor.timeout)
-		close(quit)
-	}()
+// render implements the templatedProvider interface.
+func (p *prometheusProvider) render(data *templateData) error {
+	if err := p.templates.render(data, "address", &p.addr); err != nil {
+		return err
+	}
+	return p.templates.render(data, "query", &p.query)
+}
 
-	for {
-		select {
-		case <-ticker.C:
-			debug("Processing URL %s", req.URL.String())
+// Query implements the Provider interface by running an instant query, or a
+// range query, against the Prometheus HTTP API.
+func (p *prometheusProvider) Query(ctx context.Context) (*Result, error) {
+	if p.rangeWindow > 0 {
+		return p.queryRange(ctx)
+	}
 
-			res, err := client.Do(req)
-			if err != nil {
-				return prettyError(err)
-			}
+	response, err := p.get(ctx, "/api/v1/query", url.Values{"query": {p.query}})
+	if err != nil {
+		return nil, err
+	}
 
-			defer res.Body.Close()
+	series, err := response.series()
+	if err != nil {
+		return nil, err
+	}
 
-			body, err := ioutil.ReadAll(res.Body)
+	return p.result(series), nil
+}
 
-			if err != nil {
-				return prettyError(err)
-			}
+// get runs a query against the given endpoint of the Prometheus HTTP API and
+// returns the validated response.
+func (p *prometheusProvider) get(ctx context.Context, path string, params url.Values) (*prometheusQueryResponse, error) {
+	req, err := http.NewRequest("GET", p.addr+path, nil)
+	if err != nil {
+		return nil, err
+	}
 
-			response := &prometheusQueryResponse{}
-			err = json.Unmarshal(body, response)
-			if err != nil {
-				return prettyError(err)
-			}
+	req.URL.RawQuery = params.Encode()
 
-			debug("Response: %v", response)
-			debug("Result count: %d", len(response.Data.Result))
+	debug("Processing URL %s", req.URL.Redacted())
 
-			if len(response.Data.Result) > int(monitor.expectedResultCount) {
-				ticker.Stop()
+	res, err := p.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
 
-				fmt.Fprintf(m.out, "Failure detected, rolling back...\n")
+	defer res.Body.Close()
 
-				_, err := m.client.RollbackRelease(
-					m.name,
-					helm.RollbackDryRun(monitor.dryRun),
-					helm.RollbackRecreate(false),
-					helm.RollbackForce(monitor.force),
-					helm.RollbackDisableHooks(monitor.disableHooks),
-					helm.RollbackVersion(0),
-					helm.RollbackTimeout(monitor.rollbackTimeout),
-					helm.RollbackWait(monitor.wait))
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
 
-				if err != nil {
-					return prettyError(err)
-				}
+	response := &prometheusQueryResponse{}
+	err = json.Unmarshal(body, response)
+	if err != nil {
+		if res.StatusCode/100 != 2 {
+			return nil, fmt.Errorf("unexpected status %s", res.Status)
+		}
+		return nil, fmt.Errorf("invalid response: %s", err)
+	}
 
-				fmt.Fprintf(m.out, "Successfully rolled back to previous revision!\n")
-				return nil
-			}
+	debug("Response: %s", body)
 
-		case <-quit:
-			ticker.Stop()
-			debug("Quitting...")
-			return nil
+	if err := response.validate(res); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// result returns the number of series and the sum of their sample values. With
+// a threshold, the count is the number of series whose sample value breaches
+// the threshold.
+func (p *prometheusProvider) result(series []prometheusSeries) *Result {
+	result := &Result{
+		Count:    int64(len(series)),
+		NoData:   len(series) == 0,
+		Multiple: len(series) > 1,
+	}
+
+	for _, s := range series {
+		if !math.IsNaN(s.value) {
+			result.Value += s.value
 		}
 	}
+
+	if p.threshold == nil {
+		return result
+	}
+
+	result.Count = 0
+	for _, s := range series {
+		if p.breaches(s.value) {
+			result.Count++
+			result.Breaching = append(result.Breaching, s.String())
+		}
+	}
+
+	return result
 }
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/helm/pkg/helm"
+)
+
+const monitorPrometheusAlertsDesc = `
+This command monitor a release by polling the alerts of a Prometheus server at
+a given interval and take care of rolling back to the previous version if a
+firing alert matches the given label matchers.
+
+Example:
+
+  $ helm monitor prometheus-alerts my-release \
+      --matcher 'release="{{ .Release.Name }}"' \
+      --matcher severity=critical
+
+
+Reference:
+
+  https://prometheus.io/docs/prometheus/latest/querying/api/#alerts
+
+`
+
+type monitorPrometheusAlertsCmd struct {
+	name     string
+	out      io.Writer
+	client   helm.Interface
+	provider *prometheusAlertsProvider
+	matchers []string
+	http     httpOptions
+}
+
+// prometheusAlertsProvider counts the firing alerts of Prometheus matching a
+// set of label matchers, and optionally the pending ones.
+type prometheusAlertsProvider struct {
+	addr     string
+	matchers []*labelMatcher
+	pending  bool
+
+	// ignoreExisting ignores the alerts which were active before the
+	// monitoring started, since is the time the provider was rendered at the
+	// start of the monitoring or of its first query
+	ignoreExisting bool
+	since          time.Time
+	mu             sync.Mutex
+
+	templates  templates
+	httpClient *http.Client
+}
+
+type prometheusAlertsResponse struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+	Data      struct {
+		Alerts []*prometheusAlert `json:"alerts"`
+	} `json:"data"`
+}
+
+type prometheusAlert struct {
+	Labels   map[string]string `json:"labels"`
+	State    string            `json:"state"`
+	ActiveAt time.Time         `json:"activeAt"`
+}
+
+func (a *prometheusAlert) String() string {
+	return fmt.Sprintf("%s%s %s since %s", a.Labels["alertname"], formatLabels(a.Labels), a.State, a.ActiveAt.Local().Format("15:04:05"))
+}
+
+func newMonitorPrometheusAlertsCmd(out io.Writer) *cobra.Command {
+	m := &monitorPrometheusAlertsCmd{
+		out:      out,
+		provider: newPrometheusAlertsProvider(),
+	}
+
+	cmd := &cobra.Command{
+		Use:     "prometheus-alerts [flags] RELEASE",
+		Short:   "watch the alerts of a prometheus server",
+		Long:    monitorPrometheusAlertsDesc,
+		PreRunE: setupConnection,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return fmt.Errorf("This command needs 1 argument: release name")
+			}
+
+			m.name = args[0]
+			m.client = ensureHelmClient(m.client)
+
+			return m.run()
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&m.provider.addr, "prometheus", defaultPrometheusAddr, "prometheus address")
+	f.StringArrayVar(&m.matchers, "matcher", []string{}, "label matcher of the alerts, ie: --matcher 'release=\"{{ .Release.Name }}\"' --matcher severity=critical, also accepts !=, =~ and !~")
+	f.BoolVar(&m.provider.pending, "pending", false, "also trigger on pending alerts")
+	f.BoolVar(&m.provider.ignoreExisting, "ignore-existing", false, "ignore the alerts which were already active when the monitoring started")
+	m.http.addFlags(f)
+
+	cmd.MarkFlagRequired("matcher")
+
+	return cmd
+}
+
+func (m *monitorPrometheusAlertsCmd) run() error {
+	matchers, err := parseLabelMatchers(m.matchers)
+	if err != nil {
+		return err
+	}
+	m.provider.matchers = matchers
+
+	m.provider.httpClient, err = m.http.client(5 * time.Second)
+	if err != nil {
+		return err
+	}
+
+	c, err := newCheck("prometheus-alerts", m.provider)
+	if err != nil {
+		return err
+	}
+
+	return newEngine(m.name, m.out, m.client, c).run(context.Background())
+}
+
+func newPrometheusAlertsProvider() *prometheusAlertsProvider {
+	return &prometheusAlertsProvider{
+		addr:       defaultPrometheusAddr,
+		matchers:   []*labelMatcher{},
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// render implements the templatedProvider interface, it marks the start of
+// the monitoring.
+func (p *prometheusAlertsProvider) render(data *templateData) error {
+	p.start()
+
+	if err := p.templates.render(data, "address", &p.addr); err != nil {
+		return err
+	}
+	for _, m := range p.matchers {
+		if err := m.render(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query implements the Provider interface by listing the alerts of
+// Prometheus and counting the ones matching the matchers.
+func (p *prometheusAlertsProvider) Query(ctx context.Context) (*Result, error) {
+	since := p.start()
+
+	req, err := http.NewRequest("GET", p.addr+"/api/v1/alerts", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	debug("Processing URL %s", req.URL.Redacted())
+
+	res, err := p.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &prometheusAlertsResponse{}
+	err = json.Unmarshal(body, response)
+	if err != nil {
+		if res.StatusCode/100 != 2 {
+			return nil, fmt.Errorf("unexpected status %s", res.Status)
+		}
+		return nil, fmt.Errorf("invalid response: %s", err)
+	}
+
+	debug("Response: %s", body)
+
+	if response.Status == "error" {
+		return nil, fmt.Errorf("%s: %s", response.ErrorType, response.Error)
+	}
+	if res.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	result := &Result{}
+	for _, alert := range response.Data.Alerts {
+		if !p.matches(alert, since) {
+			continue
+		}
+		result.Count++
+		result.Breaching = append(result.Breaching, alert.String())
+	}
+	result.Value = float64(result.Count)
+
+	return result, nil
+}
+
+// start returns the start of the monitoring, set on the first call.
+func (p *prometheusAlertsProvider) start() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.since.IsZero() {
+		p.since = time.Now()
+	}
+	return p.since
+}
+
+// matches returns true if the alert is firing, or pending if enabled, and
+// matches the matchers. Alerts active before the start of the monitoring are
+// ignored if ignoreExisting is set.
+func (p *prometheusAlertsProvider) matches(alert *prometheusAlert, since time.Time) bool {
+	switch alert.State {
+	case "firing":
+	case "pending":
+		if !p.pending {
+			return false
+		}
+	default:
+		return false
+	}
+
+	if p.ignoreExisting && alert.ActiveAt.Before(since) {
+		return false
+	}
+
+	return matchLabels(p.matchers, alert.Labels)
+}
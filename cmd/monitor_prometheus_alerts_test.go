@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPrometheusAlertsProviderQuery(t *testing.T) {
+	now := time.Now()
+	alert := func(name, state string, activeAt time.Time) string {
+		return fmt.Sprintf(`{"labels":{"alertname":%q,"release":"my-release","severity":"critical"},"state":%q,"activeAt":%q}`,
+			name, state, activeAt.Format(time.RFC3339Nano))
+	}
+
+	for _, test := range []struct {
+		name           string
+		alerts         []string
+		pending        bool
+		ignoreExisting bool
+		expectedCount  int64
+	}{
+		{
+			name:          "it should count the firing alerts matching the matchers",
+			alerts:        []string{alert("HighErrorRate", "firing", now), `{"labels":{"alertname":"Other","release":"other"},"state":"firing"}`},
+			expectedCount: 1,
+		},
+		{
+			name:   "it should ignore the pending alerts",
+			alerts: []string{alert("HighErrorRate", "pending", now)},
+		},
+		{
+			name:          "it should count the pending alerts if enabled",
+			alerts:        []string{alert("HighErrorRate", "pending", now)},
+			pending:       true,
+			expectedCount: 1,
+		},
+		{
+			name:           "it should ignore the alerts active before the monitoring started",
+			alerts:         []string{alert("HighErrorRate", "firing", now.Add(-time.Hour)), alert("HighLatency", "firing", now.Add(time.Minute))},
+			ignoreExisting: true,
+			expectedCount:  1,
+		},
+		{
+			name:          "it should count the alerts active before the monitoring started by default",
+			alerts:        []string{alert("HighErrorRate", "firing", now.Add(-time.Hour))},
+			expectedCount: 1,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			body := `{"status":"success","data":{"alerts":[`
+			for i, a := range test.alerts {
+				if i > 0 {
+					body += ","
+				}
+				body += a
+			}
+			body += `]}}`
+
+			server := newTestServer(http.StatusOK, body)
+			defer server.Close()
+
+			p := newPrometheusAlertsProvider()
+			p.addr = server.URL
+			p.matchers, _ = parseLabelMatchers([]string{`release="my-release"`, `severity=critical`})
+			p.pending = test.pending
+			p.ignoreExisting = test.ignoreExisting
+			p.since = now
+
+			result, err := p.Query(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Count != test.expectedCount || len(result.Breaching) != int(test.expectedCount) {
+				t.Errorf("\ngiven %s\nexpected: %d alert(s)\ngot: %d alert(s), %v\n", body, test.expectedCount, result.Count, result.Breaching)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+func TestPrometheusProviderQuery(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		threshold string
+		body      string
+		expected  *Result
+	}{
+		{
+			name:     "it should count the series of a vector",
+			body:     `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"job":"api"},"value":[1,"0.5"]},{"metric":{"job":"web"},"value":[1,"0.25"]}]}}`,
+			expected: &Result{Count: 2, Value: 0.75, Multiple: true},
+		},
+		{
+			name:      "it should count the series of a vector breaching the threshold",
+			threshold: "> 0.01",
+			body:      `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"job":"api","code":"500"},"value":[1,"0.02"]},{"metric":{"job":"web"},"value":[1,"0.001"]}]}}`,
+			expected:  &Result{Count: 1, Value: 0.021, Multiple: true, Breaching: []string{`{code="500", job="api"} 0.02`}},
+		},
+		{
+			name:      "it should compare the latest sample of every series of a matrix",
+			threshold: "> 0.01",
+			body:      `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"job":"api"},"values":[[1,"0.5"],[2,"0.005"]]},{"metric":{"job":"web"},"values":[[1,"0"],[2,"0.5"]]},{"metric":{"job":"db"},"values":[]}]}}`,
+			expected:  &Result{Count: 1, Value: 0.505, Multiple: true, Breaching: []string{`{job="web"} 0.5`}},
+		},
+		{
+			name:      "it should compare a scalar",
+			threshold: ">= 2",
+			body:      `{"status":"success","data":{"resultType":"scalar","result":[1,"2"]}}`,
+			expected:  &Result{Count: 1, Value: 2, Breaching: []string{`{} 2`}},
+		},
+		{
+			name:      "it should not compare a string which isn't a number",
+			threshold: "!= 0",
+			body:      `{"status":"success","data":{"resultType":"string","result":[1,"foo"]}}`,
+			expected:  &Result{},
+		},
+		{
+			name:      "it should not compare a NaN sample value",
+			threshold: "!= 0",
+			body:      `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1,"NaN"]}]}}`,
+			expected:  &Result{},
+		},
+		{
+			name:      "it should report an empty vector as no data",
+			threshold: "> 0.01",
+			body:      `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+			expected:  &Result{NoData: true},
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			server := newTestServer(http.StatusOK, test.body)
+			defer server.Close()
+
+			p := newPrometheusProvider()
+			p.addr = server.URL
+			p.query = "up"
+			if test.threshold != "" {
+				threshold, err := parseThreshold(test.threshold)
+				if err != nil {
+					t.Fatal(err)
+				}
+				p.threshold = threshold
+			}
+
+			result, err := p.Query(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// round the sum of the sample values to compare it
+			result.Value = float64(int64(result.Value*1000+0.5)) / 1000
+
+			if !reflect.DeepEqual(test.expected, result) {
+				t.Errorf(
+					"\ngiven %s\nexpected: %v\ngot: %v\n",
+					test.body,
+					spew.Sdump(test.expected),
+					spew.Sdump(result),
+				)
+			}
+		})
+	}
+}
+
+func TestParseThreshold(t *testing.T) {
+	if _, err := parseThreshold("> 10%"); err == nil {
+		t.Errorf("expected a relative threshold to be rejected")
+	}
+}
+
+func TestPrometheusProviderFromSpec(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "alice" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"value":[1,"1"]}]}}`)
+	}))
+	defer server.Close()
+
+	c := &checkSpec{
+		Provider:  "prometheus",
+		Address:   server.URL,
+		Query:     "up",
+		BasicAuth: &basicAuthSpec{Username: "alice", Password: "secret"},
+	}
+
+	result, err := c.provider().Query(context.Background())
+	if err != nil || result.Count != 1 {
+		t.Errorf("expected 1 series, got %v, error %v", result, err)
+	}
+}
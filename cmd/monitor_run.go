@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"k8s.io/helm/pkg/helm"
+)
+
+const monitorRunDesc = `
+This command monitor a release as described by a spec file written in YAML or
+JSON. The spec describes the release, the checks to run and the rollback
+options. Command line flags take precedence over the values of the spec and the
+release name can be provided as argument.
+
+All the checks are queried concurrently at each interval and a single rollback
+is triggered depending on the rule: when any check failed (default), when all
+the checks failed, or when at least a quorum of checks failed.
+
+Example:
+
+  $ helm monitor run -f ./examples/monitor.yaml
+
+Example spec:
+
+  release: my-release
+  interval: 10s
+  timeout: 5m
+  rollback:
+    wait: true
+    timeout: 5m
+  rule: any
+  checks:
+    - name: http-errors
+      provider: prometheus
+      address: http://prometheus:9090
+      query: rate(http_requests_total{code=~"^5.*$"}[5m]) > 0
+      expectedResultCount: 0
+    - name: critical-alerts
+      provider: prometheus-alerts
+      address: http://prometheus:9090
+      matchers:
+        - release="{{ .Release.Name }}"
+        - severity=critical
+    - name: successful-requests
+      provider: elasticsearch
+      query: status:200 AND kubernetes.labels.app:app
+      condition: < -50%
+    - name: exceptions
+      provider: sentry
+      address: https://sentry-endpoint/
+      apiKey: <SENTRY_API_KEY>
+      organization: my-organization
+      project: my-project
+      tags:
+        - release=2.0.0
+
+`
+
+type monitorRunCmd struct {
+	name   string
+	out    io.Writer
+	client helm.Interface
+	file   string
+	rule   string
+	quorum int
+}
+
+func newMonitorRunCmd(out io.Writer) *cobra.Command {
+	m := &monitorRunCmd{
+		out: out,
+	}
+
+	cmd := &cobra.Command{
+		Use:     "run [flags] -f SPEC [RELEASE]",
+		Short:   "run the monitor described by a spec file",
+		Long:    monitorRunDesc,
+		PreRunE: setupConnection,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				return fmt.Errorf("This command accepts at most 1 argument: release name")
+			}
+
+			if len(args) == 1 {
+				m.name = args[0]
+			}
+
+			spec, err := loadSpec(m.file)
+			if err != nil {
+				return err
+			}
+
+			if m.name != "" {
+				spec.Release = m.name
+			}
+
+			if cmd.Flags().Changed("rule") || cmd.Flags().Changed("quorum") {
+				if cmd.Flags().Changed("rule") {
+					spec.Rule = m.rule
+				}
+				if cmd.Flags().Changed("quorum") {
+					spec.Quorum = m.quorum
+				}
+				if _, err := newCombinationRule(spec.Rule, spec.Quorum, len(spec.Checks)); err != nil {
+					return err
+				}
+			}
+
+			if err := spec.validate(); err != nil {
+				return err
+			}
+
+			m.client = ensureHelmClient(m.client)
+
+			e := newEngine(spec.Release, m.out, m.client)
+			if err := spec.configure(e, cmd.Flags()); err != nil {
+				return err
+			}
+
+			return e.run(context.Background())
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVarP(&m.file, "file", "f", "", "path of the monitor spec file, in YAML or JSON")
+	f.StringVar(&m.rule, "rule", ruleAny, "rule deciding when to rollback depending on the failed checks: any, all or quorum")
+	f.IntVar(&m.quorum, "quorum", 0, "number of failed checks required to rollback when using the quorum rule")
+
+	cmd.MarkFlagRequired("file")
+
+	return cmd
+}
@@ -1,16 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
-	"os"
-	"os/signal"
 	"regexp"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -45,17 +44,29 @@ Example with event message matching regular expression:
 
 `
 
+const defaultSentryAddr = "http://localhost:9000"
+
 type monitorSentryCmd struct {
-	name               string
-	out                io.Writer
-	client             helm.Interface
-	sentryAddr         string
-	sentryAPIKey       string
-	sentryOrganization string
-	sentryProject      string
-	message            string
-	regexp             bool
-	tags               []string
+	name          string
+	out           io.Writer
+	client        helm.Interface
+	provider      *sentryProvider
+	volumeMessage string
+}
+
+// sentryProvider lists the events of a Sentry project and counts the ones
+// matching a message and a set of tags.
+type sentryProvider struct {
+	addr         string
+	apiKey       string
+	organization string
+	project      string
+	message      string
+	regexp       bool
+	tags         []string
+	rawTags      []string
+	templates    templates
+	httpClient   *http.Client
 }
 
 type tag struct {
@@ -70,7 +81,8 @@ type sentryEvent struct {
 
 func newMonitorSentryCmd(out io.Writer) *cobra.Command {
 	m := &monitorSentryCmd{
-		out: out,
+		out:      out,
+		provider: newSentryProvider(),
 	}
 
 	cmd := &cobra.Command{
@@ -80,7 +92,7 @@ func newMonitorSentryCmd(out io.Writer) *cobra.Command {
 		PreRunE: setupConnection,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) != 1 {
-				return fmt.Errorf("This command neeeds 1 argument: release name")
+				return fmt.Errorf("This command needs 1 argument: release name")
 			}
 
 			m.name = args[0]
@@ -91,13 +103,14 @@ func newMonitorSentryCmd(out io.Writer) *cobra.Command {
 	}
 
 	f := cmd.Flags()
-	f.StringVar(&m.sentryAddr, "sentry", "http://localhost:9000", "sentry address")
-	f.StringVar(&m.sentryAPIKey, "api-key", "", "sentry api key")
-	f.StringVar(&m.sentryOrganization, "organization", "", "sentry organization")
-	f.StringVar(&m.sentryProject, "project", "", "sentry project")
-	f.StringVar(&m.message, "message", "", "event message to match")
-	f.BoolVar(&m.regexp, "regexp", false, "enable regular expression")
-	f.StringSliceVar(&m.tags, "tag", []string{}, "tags, ie: --tag release=2.0.0 --tag environment=production")
+	f.StringVar(&m.provider.addr, "sentry", defaultSentryAddr, "sentry address")
+	f.StringVar(&m.provider.apiKey, "api-key", "", "sentry api key")
+	f.StringVar(&m.provider.organization, "organization", "", "sentry organization")
+	f.StringVar(&m.provider.project, "project", "", "sentry project")
+	f.StringVar(&m.provider.message, "message", "", "event message to match")
+	f.BoolVar(&m.provider.regexp, "regexp", false, "enable regular expression")
+	f.StringSliceVar(&m.provider.tags, "tag", []string{}, "tags, ie: --tag release=2.0.0 --tag environment=production")
+	f.StringVar(&m.volumeMessage, "volume-message", "", "message of the events measuring the traffic volume (ie: transactions), matched using the same --regexp and --tag options, the event count is compared to --min-volume")
 
 	cmd.MarkFlagRequired("api-key")
 	cmd.MarkFlagRequired("organization")
@@ -106,6 +119,44 @@ func newMonitorSentryCmd(out io.Writer) *cobra.Command {
 	return cmd
 }
 
+type sentryErrorResponse struct {
+	Detail string `json:"detail"`
+}
+
+// validateSentryResponse checks the status of the response and that it is a
+// list of events, not an error envelope. Bad requests and unknown projects are
+// errors of the query itself, while any other error is an error of the
+// datasource.
+func validateSentryResponse(res *http.Response, body []byte) error {
+	detail := ""
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		envelope := &sentryErrorResponse{}
+		if err := json.Unmarshal(trimmed, envelope); err == nil {
+			detail = envelope.Detail
+		}
+		if detail == "" {
+			detail = "expected a list of events, got an object"
+		}
+	}
+
+	if res.StatusCode/100 != 2 {
+		if detail == "" {
+			detail = res.Status
+		}
+		switch res.StatusCode {
+		case http.StatusBadRequest, http.StatusNotFound:
+			return newQueryError("%s", detail)
+		}
+		return fmt.Errorf("%s", detail)
+	}
+
+	if detail != "" {
+		return fmt.Errorf("%s", detail)
+	}
+
+	return nil
+}
+
 func convertStringToTags(s []string) (tagList []*tag) {
 	tagList = []*tag{}
 	for _, t := range s {
@@ -173,102 +224,106 @@ func matchTags(tagList []*tag, matchTagList []*tag) bool {
 }
 
 func (m *monitorSentryCmd) run() error {
-	_, err := m.client.ReleaseContent(m.name)
+	c, err := newCheck("sentry", m.provider)
 	if err != nil {
-		return prettyError(err)
+		return err
 	}
 
-	fmt.Fprintf(m.out, "Monitoring %s...\n", m.name)
-
-	client := &http.Client{Timeout: 5 * time.Second}
-
-	req, err := http.NewRequest("GET", m.sentryAddr+"/api/0/projects/"+m.sentryOrganization+"/"+m.sentryProject+"/events/", nil)
-	if err != nil {
-		return prettyError(err)
+	if m.volumeMessage != "" {
+		volume := *m.provider
+		volume.message = m.volumeMessage
+		c.volume = &volume
 	}
 
-	req.Header.Add("Authorization", "Bearer "+m.sentryAPIKey)
-
-	quit := make(chan os.Signal)
-	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
-
-	ticker := time.NewTicker(time.Second * time.Duration(monitor.interval))
-
-	go func() {
-		time.Sleep(time.Second * time.Duration(monitor.timeout))
-		fmt.Fprintf(m.out, "No results after %d second(s)\n", monitor.timeout)
-		close(quit)
-	}()
-
-	for {
-		select {
-		case <-ticker.C:
-			debug("Processing URL %s", req.URL.String())
+	return newEngine(m.name, m.out, m.client, c).run(context.Background())
+}
 
-			res, err := client.Do(req)
-			if err != nil {
-				return prettyError(err)
-			}
+func newSentryProvider() *sentryProvider {
+	return &sentryProvider{
+		addr:       defaultSentryAddr,
+		tags:       []string{},
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
 
-			defer res.Body.Close()
+// render implements the templatedProvider interface.
+func (p *sentryProvider) render(data *templateData) error {
+	if err := p.templates.render(data, "address", &p.addr); err != nil {
+		return err
+	}
+	if err := p.templates.render(data, "message", &p.message); err != nil {
+		return err
+	}
 
-			body, err := ioutil.ReadAll(res.Body)
+	if p.rawTags == nil {
+		p.rawTags = p.tags
+	}
+	tags := make([]string, len(p.rawTags))
+	for i, tag := range p.rawTags {
+		tags[i] = tag
+		if err := renderTemplate(data, "tag", &tags[i]); err != nil {
+			return err
+		}
+	}
+	p.tags = tags
+	return nil
+}
 
-			if err != nil {
-				return prettyError(err)
-			}
+// Query implements the Provider interface by listing the project events
+// and counting the ones matching the message and tags.
+func (p *sentryProvider) Query(ctx context.Context) (*Result, error) {
+	req, err := http.NewRequest("GET", p.addr+"/api/0/projects/"+p.organization+"/"+p.project+"/events/", nil)
+	if err != nil {
+		return nil, err
+	}
 
-			var response []*sentryEvent
-			err = json.Unmarshal(body, &response)
-			if err != nil {
-				return prettyError(err)
-			}
+	req.Header.Add("Authorization", "Bearer "+p.apiKey)
 
-			debug("Response: %v", response)
-			debug("Result count: %d", len(response))
+	debug("Processing URL %s", req.URL.Redacted())
 
-			time.Sleep(30 * time.Second)
+	res, err := p.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
 
-			events, err := matchEvents(
-				response,
-				m.message,
-				convertStringToTags(m.tags),
-				m.regexp,
-			)
+	defer res.Body.Close()
 
-			if err != nil {
-				return prettyError(err)
-			}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
 
-			debug("Matched events: %d", len(events))
+	if err := validateSentryResponse(res, body); err != nil {
+		return nil, err
+	}
 
-			if len(events) > int(monitor.expectedResultCount) {
-				ticker.Stop()
+	var response []*sentryEvent
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response: %s", err)
+	}
 
-				fmt.Fprintf(m.out, "Failure detected, rolling back...\n")
+	debug("Response: %v", response)
+	debug("Event count: %d", len(response))
 
-				_, err := m.client.RollbackRelease(
-					m.name,
-					helm.RollbackDryRun(monitor.dryRun),
-					helm.RollbackRecreate(false),
-					helm.RollbackForce(monitor.force),
-					helm.RollbackDisableHooks(monitor.disableHooks),
-					helm.RollbackVersion(0),
-					helm.RollbackTimeout(monitor.rollbackTimeout),
-					helm.RollbackWait(monitor.wait))
+	events, err := matchEvents(
+		response,
+		p.message,
+		convertStringToTags(p.tags),
+		p.regexp,
+	)
 
-				if err != nil {
-					return prettyError(err)
-				}
+	// the message is only known to be an invalid regular expression once
+	// rendered, it is rejected as the query itself
+	if err != nil {
+		return nil, newQueryError("invalid regular expression: %s", err)
+	}
 
-				fmt.Fprintf(m.out, "Successfully rolled back to previous revision!\n")
-				return nil
-			}
+	debug("Matched events: %d", len(events))
 
-		case <-quit:
-			ticker.Stop()
-			debug("Quitting...")
-			return nil
-		}
-	}
+	return &Result{
+		Count:  int64(len(events)),
+		Value:  float64(len(events)),
+		NoData: len(events) == 0,
+	}, nil
 }
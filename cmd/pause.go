@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// pauseAnnotation pauses the monitoring of a release when set to true on the
+// storage object of its pinned revision, the ConfigMap or Secret named
+// <release>.v<revision> in the Tiller namespace.
+const pauseAnnotation = "helm-monitor/paused"
+
+// silence is an Alertmanager silence, as returned by the v2 API.
+type silence struct {
+	ID        string `json:"id"`
+	CreatedBy string `json:"createdBy"`
+	Comment   string `json:"comment"`
+	Status    struct {
+		State string `json:"state"`
+	} `json:"status"`
+	Matchers []struct {
+		Name    string `json:"name"`
+		Value   string `json:"value"`
+		IsRegex bool   `json:"isRegex"`
+		IsEqual *bool  `json:"isEqual"`
+	} `json:"matchers"`
+}
+
+// pauseReason returns what paused the monitoring of the release, empty if it
+// is not paused. The pause markers which can't be read are ignored, a broken
+// Kubernetes API or Alertmanager never prevents a remediation.
+func (e *engine) pauseReason(parent context.Context) string {
+	ctx, cancel := context.WithTimeout(parent, e.interval)
+	defer cancel()
+
+	var annotated string
+	err := call(ctx, func() error {
+		var err error
+		annotated, err = e.pauseAnnotationReason()
+		return err
+	})
+	if err != nil {
+		debug("Could not read the %s annotation: %s", pauseAnnotation, err)
+	} else if annotated != "" {
+		return annotated
+	}
+
+	if e.pauseAlertmanager == "" {
+		return ""
+	}
+
+	reason, err := e.pauseSilenceReason(ctx)
+	if err != nil {
+		fmt.Fprintf(e.out, "Could not read the silences of %s: %s\n", redactURL(e.pauseAlertmanager), err)
+	}
+	return reason
+}
+
+// pauseAnnotationReason checks the pause annotation on the storage object of
+// the pinned revision, stored in a ConfigMap or a Secret depending on the
+// Tiller storage driver.
+func (e *engine) pauseAnnotationReason() (string, error) {
+	kube, err := e.kubeClient()
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("%s.v%d", e.name, e.revision)
+
+	var annotations map[string]string
+	cm, err := kube.CoreV1().ConfigMaps(e.tillerNamespace).Get(name, metav1.GetOptions{})
+	if err == nil {
+		annotations = cm.Annotations
+	} else if apierrors.IsNotFound(err) {
+		secret, err := kube.CoreV1().Secrets(e.tillerNamespace).Get(name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		annotations = secret.Annotations
+	} else {
+		return "", err
+	}
+
+	if annotations[pauseAnnotation] != "true" {
+		return "", nil
+	}
+	return fmt.Sprintf("the %s annotation on %s/%s", pauseAnnotation, e.tillerNamespace, name), nil
+}
+
+// pauseSilenceReason looks for an active Alertmanager silence with a matcher
+// on the pause label matching the release name.
+func (e *engine) pauseSilenceReason(ctx context.Context) (string, error) {
+	// the silences are not filtered by Alertmanager, whose filters ignore
+	// the regular expression matchers of the silences
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(e.pauseAlertmanager, "/")+"/api/v2/silences", nil)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := e.pauseClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	silences := []*silence{}
+	if err := json.NewDecoder(res.Body).Decode(&silences); err != nil {
+		return "", fmt.Errorf("invalid response: %s", err)
+	}
+
+	for _, s := range silences {
+		if s.Status.State == "active" && s.matches(e.pauseLabel, e.name) {
+			return fmt.Sprintf("silence %s created by %s (%s)", s.ID, s.CreatedBy, s.Comment), nil
+		}
+	}
+
+	return "", nil
+}
+
+// matches returns true if the silence has a positive matcher on the label
+// matching the value.
+func (s *silence) matches(label, value string) bool {
+	for _, m := range s.Matchers {
+		if m.Name != label || (m.IsEqual != nil && !*m.IsEqual) {
+			continue
+		}
+		if !m.IsRegex {
+			if m.Value == value {
+				return true
+			}
+			continue
+		}
+		if re, err := regexp.Compile("^(?:" + m.Value + ")$"); err == nil && re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
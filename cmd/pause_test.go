@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEnginePause(t *testing.T) {
+	for _, test := range []struct {
+		name              string
+		annotations       map[string]string
+		silences          string
+		token             string
+		expectedRollbacks int
+	}{
+		{
+			name:              "it should rollback when not paused",
+			silences:          `[]`,
+			expectedRollbacks: 1,
+		},
+		{
+			name:        "it should not rollback when paused by the annotation",
+			annotations: map[string]string{pauseAnnotation: "true"},
+			silences:    `[]`,
+		},
+		{
+			name:     "it should not rollback when paused by a silence",
+			silences: `[{"id": "1", "createdBy": "alice", "status": {"state": "active"}, "matchers": [{"name": "release", "value": "my-.*", "isRegex": true}]}]`,
+		},
+		{
+			name:     "it should not rollback when paused by a silence read with the bearer token",
+			silences: `[{"id": "1", "createdBy": "alice", "status": {"state": "active"}, "matchers": [{"name": "release", "value": "my-release"}]}]`,
+			token:    "secret",
+		},
+		{
+			name:              "it should rollback when the silence expired",
+			silences:          `[{"id": "1", "createdBy": "alice", "status": {"state": "expired"}, "matchers": [{"name": "release", "value": "my-release"}]}]`,
+			expectedRollbacks: 1,
+		},
+		{
+			name:              "it should rollback when the silence excludes the release",
+			silences:          `[{"id": "1", "createdBy": "alice", "status": {"state": "active"}, "matchers": [{"name": "release", "value": "my-release", "isEqual": false}]}]`,
+			expectedRollbacks: 1,
+		},
+		{
+			name:              "it should rollback when the silences can't be read",
+			expectedRollbacks: 1,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if test.silences == "" {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				if test.token != "" && r.Header.Get("Authorization") != "Bearer "+test.token {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				fmt.Fprintf(w, "%s", test.silences)
+			}))
+			defer server.Close()
+
+			client := newFakeHelmClient("my-release")
+			e := newTestEngine(client, combinationRule{kind: ruleAny}, onDatasourceErrorAbort,
+				&fakeProvider{results: []*Result{{Count: 2}}})
+			e.pauseAlertmanager = server.URL
+			e.pauseHTTP.bearerToken = test.token
+			e.pauseLabel = "release"
+			// the silences are read within an interval
+			e.interval = 10 * time.Millisecond
+			e.timeout = 200 * time.Millisecond
+
+			_, err := e.kube.CoreV1().ConfigMaps(e.tillerNamespace).Create(&v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "my-release.v2", Annotations: test.annotations},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = e.run(context.Background())
+			if err != nil || client.rollbacks != test.expectedRollbacks {
+				t.Errorf(
+					"\nexpected: %d rollback(s), no error\ngot: %d rollback(s), error %v\n",
+					test.expectedRollbacks,
+					client.rollbacks,
+					err,
+				)
+			}
+
+			revisions, err := (&releaseRecords{kube: e.kube, namespace: e.tillerNamespace}).revisions(e.name, recordKnownGood)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(revisions) != 0 {
+				t.Errorf("expected no known-good revision, got %v", revisions)
+			}
+		})
+	}
+
+	t.Run("it should rollback once resumed", func(t *testing.T) {
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls == 1 {
+				fmt.Fprintf(w, `[{"id": "1", "status": {"state": "active"}, "matchers": [{"name": "release", "value": "my-release"}]}]`)
+				return
+			}
+			fmt.Fprintf(w, `[]`)
+		}))
+		defer server.Close()
+
+		client := newFakeHelmClient("my-release")
+		e := newTestEngine(client, combinationRule{kind: ruleAny}, onDatasourceErrorAbort,
+			&fakeProvider{results: []*Result{{Count: 2}}})
+		e.pauseAlertmanager = server.URL
+		e.pauseLabel = "release"
+		e.interval = 10 * time.Millisecond
+		e.timeout = 200 * time.Millisecond
+
+		err := e.run(context.Background())
+		if err != nil || client.rollbacks != 1 || calls != 2 {
+			t.Errorf("\nexpected: 1 rollback after 2 pause checks, no error\ngot: %d rollback(s) after %d pause check(s), error %v\n",
+				client.rollbacks, calls, err)
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// failurePolicy decides when a failing check is considered as breached and
+// may trigger a rollback. Every configured criterion must be met: the last
+// evaluations must be failing consecutively, enough of them must be failing
+// within the sliding window and the check must be failing for long enough.
+type failurePolicy struct {
+	// consecutiveFailures is the number of consecutive failing evaluations.
+	consecutiveFailures int
+
+	// windowFailures is the number of failing evaluations within the last
+	// windowSize evaluations, disabled if windowSize is 0.
+	windowFailures int
+	windowSize     int
+
+	// failureDuration is the minimum duration the check must be failing
+	// continuously.
+	failureDuration time.Duration
+}
+
+func (p failurePolicy) validate() error {
+	if p.consecutiveFailures < 1 {
+		return fmt.Errorf("consecutive failures must be greater than 0, got %d", p.consecutiveFailures)
+	}
+	if p.windowSize < 0 {
+		return fmt.Errorf("window size must not be negative, got %d", p.windowSize)
+	}
+	if p.windowSize > 0 && (p.windowFailures < 1 || p.windowFailures > p.windowSize) {
+		return fmt.Errorf("window failures must be between 1 and the window size (%d), got %d", p.windowSize, p.windowFailures)
+	}
+	if p.failureDuration < 0 {
+		return fmt.Errorf("failure duration must not be negative, got %s", p.failureDuration)
+	}
+	return nil
+}
+
+// historySize returns the number of evaluations to keep to apply the policy.
+func (p failurePolicy) historySize() int {
+	size := 10
+	if p.consecutiveFailures > size {
+		size = p.consecutiveFailures
+	}
+	if p.windowSize > size {
+		size = p.windowSize
+	}
+	return size
+}
+
+// breached applies the policy to the history of evaluations of a check,
+// oldest first. Evaluations with insufficient volume or during the warm-up
+// are ignored.
+func (p failurePolicy) breached(all []*evaluation) bool {
+	history := []*evaluation{}
+	for _, ev := range all {
+		if !ev.insufficient && !ev.warmUp {
+			history = append(history, ev)
+		}
+	}
+
+	if len(history) == 0 {
+		return false
+	}
+
+	last := history[len(history)-1]
+	if !last.failed {
+		return false
+	}
+
+	consecutive := 0
+	for i := len(history) - 1; i >= 0 && history[i].failed; i-- {
+		consecutive++
+	}
+	if consecutive < p.consecutiveFailures {
+		return false
+	}
+
+	if p.windowSize > 0 {
+		start := len(history) - p.windowSize
+		if start < 0 {
+			start = 0
+		}
+		failures := 0
+		for _, ev := range history[start:] {
+			if ev.failed {
+				failures++
+			}
+		}
+		if failures < p.windowFailures {
+			return false
+		}
+	}
+
+	return last.time.Sub(last.failingSince) >= p.failureDuration
+}
+
+func (p failurePolicy) String() string {
+	criteria := []string{fmt.Sprintf("%d consecutive failure(s)", p.consecutiveFailures)}
+	if p.windowSize > 0 {
+		criteria = append(criteria, fmt.Sprintf("%d failure(s) out of the last %d evaluation(s)", p.windowFailures, p.windowSize))
+	}
+	if p.failureDuration > 0 {
+		criteria = append(criteria, fmt.Sprintf("failing for at least %s", p.failureDuration))
+	}
+	return strings.Join(criteria, ", ")
+}
+
+// formatHistory returns a compact representation of the history of
+// evaluations, oldest first.
+func formatHistory(history []*evaluation) string {
+	out := make([]string, len(history))
+	for i, ev := range history {
+		status, value := "ok", ev.value
+		if ev.insufficient {
+			status, value = "insufficient", ev.volume
+		} else if ev.absent {
+			status = "absent"
+		} else if ev.failed {
+			status = "failed"
+		}
+		if ev.warmUp {
+			status = "warm-up " + status
+		}
+		out[i] = fmt.Sprintf("%s %s(%g)", ev.time.Format("15:04:05"), status, value)
+	}
+	return strings.Join(out, ", ")
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// newHistory returns a history of evaluations, one per second, from a list
+// of failing states.
+func newHistory(failed ...bool) []*evaluation {
+	start := time.Date(2019, 3, 1, 10, 0, 0, 0, time.UTC)
+	c := &check{policy: failurePolicy{consecutiveFailures: 1, windowSize: len(failed)}}
+	for i, f := range failed {
+		c.record(&evaluation{
+			time:   start.Add(time.Duration(i) * time.Second),
+			result: &Result{},
+			failed: f,
+		})
+	}
+	return c.history
+}
+
+func TestFailurePolicyBreached(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		policy   failurePolicy
+		history  []*evaluation
+		expected bool
+	}{
+		{
+			name:     "it should breach on the first failure by default",
+			policy:   failurePolicy{consecutiveFailures: 1},
+			history:  newHistory(false, true),
+			expected: true,
+		},
+		{
+			name:     "it should not breach if the last evaluation succeeded",
+			policy:   failurePolicy{consecutiveFailures: 1},
+			history:  newHistory(true, false),
+			expected: false,
+		},
+		{
+			name:     "it should not breach before the number of consecutive failures",
+			policy:   failurePolicy{consecutiveFailures: 3},
+			history:  newHistory(true, false, true, true),
+			expected: false,
+		},
+		{
+			name:     "it should breach after the number of consecutive failures",
+			policy:   failurePolicy{consecutiveFailures: 3},
+			history:  newHistory(false, true, true, true),
+			expected: true,
+		},
+		{
+			name:     "it should breach when enough evaluations failed within the window",
+			policy:   failurePolicy{consecutiveFailures: 1, windowSize: 5, windowFailures: 3},
+			history:  newHistory(true, false, true, false, true),
+			expected: true,
+		},
+		{
+			name:     "it should not breach when failures are outside of the window",
+			policy:   failurePolicy{consecutiveFailures: 1, windowSize: 3, windowFailures: 2},
+			history:  newHistory(true, true, false, false, true),
+			expected: false,
+		},
+		{
+			name:     "it should not breach before the failure duration",
+			policy:   failurePolicy{consecutiveFailures: 1, failureDuration: 3 * time.Second},
+			history:  newHistory(true, false, true, true, true),
+			expected: false,
+		},
+		{
+			name:     "it should breach once failing for the failure duration",
+			policy:   failurePolicy{consecutiveFailures: 1, failureDuration: 3 * time.Second},
+			history:  newHistory(false, true, true, true, true),
+			expected: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			output := test.policy.breached(test.history)
+			if output != test.expected {
+				t.Errorf(
+					"\ngiven %v\nexpected: %v\ngot: %v\n",
+					spew.Sdump(test.policy),
+					test.expected,
+					output,
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// validateRange checks the range query settings, a range is required by the
+// for duration and defaults to the for duration plus one step.
+func (p *prometheusProvider) validateRange() error {
+	if p.rangeWindow < 0 || p.forDuration < 0 {
+		return fmt.Errorf("range and for must not be negative")
+	}
+	if p.rangeWindow == 0 && p.forDuration == 0 {
+		return nil
+	}
+	if p.step <= 0 {
+		return fmt.Errorf("step must be greater than 0")
+	}
+	if p.rangeWindow == 0 {
+		p.rangeWindow = p.forDuration + p.step
+	}
+	if p.rangeWindow < p.forDuration+p.step {
+		return fmt.Errorf("range %s is too short to evaluate for %s with a step of %s", p.rangeWindow, p.forDuration, p.step)
+	}
+	return nil
+}
+
+// breaches returns true if the sample value breaches the threshold. Without
+// threshold any sample breaches, as for an alerting rule. A NaN sample value
+// never breaches a threshold.
+func (p *prometheusProvider) breaches(v float64) bool {
+	if p.threshold == nil {
+		return true
+	}
+	return !math.IsNaN(v) && p.threshold.failed(v, 0)
+}
+
+// queryRange runs a range query over the lookback window and counts the series
+// firing at the end of the window.
+func (p *prometheusProvider) queryRange(ctx context.Context) (*Result, error) {
+	end := time.Now()
+	start := end.Add(-p.rangeWindow)
+
+	response, err := p.get(ctx, "/api/v1/query_range", url.Values{
+		"query": {p.query},
+		"start": {formatPrometheusTime(start)},
+		"end":   {formatPrometheusTime(end)},
+		"step":  {strconv.FormatFloat(p.step.Seconds(), 'f', -1, 64)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.Data.ResultType != "matrix" {
+		return nil, newQueryError("expected a matrix result, got %q", response.Data.ResultType)
+	}
+
+	series, err := response.series()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		NoData:      len(series) == 0,
+		WindowStart: start,
+		WindowEnd:   end,
+	}
+
+	for _, s := range series {
+		if !math.IsNaN(s.value) {
+			result.Value += s.value
+		}
+
+		activeSince, firingSince := p.firing(s, end)
+		if firingSince.IsZero() {
+			continue
+		}
+
+		result.Count++
+		result.Breaching = append(result.Breaching, fmt.Sprintf("%s, active since %s, firing since %s",
+			s, activeSince.Format("15:04:05"), firingSince.Format("15:04:05")))
+	}
+
+	return result, nil
+}
+
+// firing applies the semantics of the for clause of the alerting rules to the
+// samples of a series: the series is active from its first breaching sample
+// and fires once it was active for the for duration. A sample which doesn't
+// breach, or a missing sample, resets the series. It returns the time the
+// series became active and the time it fired, zero if it isn't firing at the
+// end of the window.
+func (p *prometheusProvider) firing(s prometheusSeries, end time.Time) (time.Time, time.Time) {
+	// samples are expected at every step, a larger interval is a gap
+	gap := p.step * 3 / 2
+
+	var activeSince, firingSince, last time.Time
+	for _, sample := range s.samples {
+		if !last.IsZero() && sample.time.Sub(last) > gap {
+			activeSince, firingSince = time.Time{}, time.Time{}
+		}
+		last = sample.time
+
+		if !p.breaches(sample.value) {
+			activeSince, firingSince = time.Time{}, time.Time{}
+			continue
+		}
+
+		if activeSince.IsZero() {
+			activeSince = sample.time
+		}
+		if firingSince.IsZero() && sample.time.Sub(activeSince) >= p.forDuration {
+			firingSince = sample.time
+		}
+	}
+
+	// a series which disappeared before the end of the window is resolved
+	if last.IsZero() || end.Sub(last) > gap {
+		return time.Time{}, time.Time{}
+	}
+
+	return activeSince, firingSince
+}
+
+// formatPrometheusTime formats a time as a Unix timestamp in seconds.
+func formatPrometheusTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', 3, 64)
+}
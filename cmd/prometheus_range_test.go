@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusFiring(t *testing.T) {
+	end := time.Unix(1000000, 0)
+
+	for _, test := range []struct {
+		name           string
+		threshold      string
+		values         []string
+		expectedFiring bool
+		expectedSince  time.Duration
+	}{
+		{
+			name:           "it should fire when breaching continuously for the duration",
+			threshold:      "> 0.02",
+			values:         []string{"0", "0", "0.03", "0.03", "0.04", "0.05"},
+			expectedFiring: true,
+		},
+		{
+			name:           "it should report the first time the series fired",
+			threshold:      "> 0.02",
+			values:         []string{"0.03", "0.03", "0.03", "0.03", "0.04", "0.05"},
+			expectedFiring: true,
+			expectedSince:  -2 * time.Minute,
+		},
+		{
+			name:      "it should not fire when breaching for less than the duration",
+			threshold: "> 0.02",
+			values:    []string{"0", "0", "0", "0.03", "0.04", "0.05"},
+		},
+		{
+			name:      "it should reset the series on a sample which doesn't breach",
+			threshold: "> 0.02",
+			values:    []string{"0.03", "0.03", "0.03", "0", "0.04", "0.05"},
+		},
+		{
+			name:      "it should reset the series on a gap",
+			threshold: "> 0.02",
+			values:    []string{"0.03", "0.03", "0.03", "", "0.04", "0.05"},
+		},
+		{
+			name:      "it should not fire when the series disappeared",
+			threshold: "> 0.02",
+			values:    []string{"0.03", "0.03", "0.03", "0.03", "", ""},
+		},
+		{
+			name:      "it should not fire when the series recovered",
+			threshold: "> 0.02",
+			values:    []string{"0.03", "0.03", "0.03", "0.03", "0.03", "0.01"},
+		},
+		{
+			name:           "it should fire on any sample without threshold",
+			values:         []string{"", "", "1", "1", "1", "1"},
+			expectedFiring: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			p := newPrometheusProvider()
+			p.step = time.Minute
+			p.forDuration = 3 * time.Minute
+			if test.threshold != "" {
+				p.threshold, _ = parseThreshold(test.threshold)
+			}
+
+			s := prometheusSeries{}
+			for i, v := range test.values {
+				if v == "" {
+					continue
+				}
+				value, _ := strconv.ParseFloat(v, 64)
+				sampleTime := end.Add(time.Duration(i-len(test.values)+1) * time.Minute)
+				s.samples = append(s.samples, prometheusSample{time: sampleTime, value: value})
+			}
+
+			_, firingSince := p.firing(s, end)
+
+			expected := time.Time{}
+			if test.expectedFiring {
+				expected = end.Add(test.expectedSince)
+			}
+			if !firingSince.Equal(expected) {
+				t.Errorf("\ngiven %v\nexpected firing since: %v\ngot: %v\n", test.values, expected, firingSince)
+			}
+		})
+	}
+}
+
+func TestPrometheusQueryRange(t *testing.T) {
+	var params map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query_range" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		params = r.URL.Query()
+
+		end, _ := strconv.ParseFloat(r.URL.Query().Get("end"), 64)
+		samples := []string{}
+		for i := 4; i >= 0; i-- {
+			samples = append(samples, fmt.Sprintf(`[%f,"0.05"]`, end-float64(i*60)))
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"job":"api"},"values":[%s]}]}}`,
+			strings.Join(samples, ","))
+	}))
+	defer server.Close()
+
+	p := newPrometheusProvider()
+	p.addr = server.URL
+	p.query = "errors"
+	p.threshold, _ = parseThreshold("> 0.02")
+	p.forDuration = 3 * time.Minute
+	p.step = time.Minute
+	if err := p.validateRange(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := p.Query(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Count != 1 || len(result.Breaching) != 1 || !strings.HasPrefix(result.Breaching[0], `{job="api"} 0.05, active since`) {
+		t.Errorf("expected 1 firing series, got %d: %v", result.Count, result.Breaching)
+	}
+	if window := result.WindowEnd.Sub(result.WindowStart); window != 4*time.Minute {
+		t.Errorf("expected a window of 4m, got %s", window)
+	}
+	if params["step"][0] != "60" {
+		t.Errorf("expected a step of 60 seconds, got %v", params["step"])
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Provider is implemented by every monitoring backend (Prometheus,
+// Elasticsearch, Sentry, ...). The engine calls Query at each interval and
+// decides from the returned Result whether the release should be rolled back.
+type Provider interface {
+	// Query runs the configured query against the backend. The context is
+	// cancelled when the query exceeds its timeout or monitoring stops.
+	Query(ctx context.Context) (*Result, error)
+}
+
+// Result is the outcome of a single provider query.
+type Result struct {
+	// Count is the number of results (series, documents, events, ...)
+	// returned by the query.
+	Count int64
+
+	// Value is the value measured by the query: the sum of the sample values
+	// for Prometheus, the number of documents or events for Elasticsearch and
+	// Sentry. It is used to measure the traffic volume and compared to the
+	// condition of the check.
+	Value float64
+
+	// Multiple is true if Value is the sum of several values, ie: several
+	// Prometheus series, which can't be compared to a condition.
+	Multiple bool
+
+	// NoData is true if the query didn't return any data: no series, no
+	// documents or no events.
+	NoData bool
+
+	// Breaching describes the results which matched the threshold of the
+	// provider, ie: the labelled Prometheus series.
+	Breaching []string
+
+	// WindowStart and WindowEnd delimit the time range evaluated by a range
+	// query, they are zero for an instant query.
+	WindowStart time.Time
+	WindowEnd   time.Time
+}
+
+// QueryError is returned by a provider when the backend rejected the query
+// itself, for example because of a syntax error or an unexpected result type.
+// Query errors are not retried and abort the monitoring, any other error is
+// considered as a datasource error.
+type QueryError struct {
+	Message string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("query error: %s", e.Message)
+}
+
+func newQueryError(format string, args ...interface{}) *QueryError {
+	return &QueryError{Message: fmt.Sprintf(format, args...)}
+}
+
+func isQueryError(err error) bool {
+	var queryErr *QueryError
+	return errors.As(err, &queryErr)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestServer returns a server replying with the given status and body to
+// any request.
+func newTestServer(status int, body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+}
+
+func TestProviderQueryErrors(t *testing.T) {
+	prometheus := func(addr string) Provider {
+		p := newPrometheusProvider()
+		p.addr = addr
+		p.query = "up"
+		return p
+	}
+	elasticsearch := func(addr string) Provider {
+		p := newElasticsearchProvider()
+		p.addr = addr
+		p.setQuery("level:error")
+		return p
+	}
+	sentry := func(addr string) Provider {
+		p := newSentryProvider()
+		p.addr = addr
+		p.organization = "my-org"
+		p.project = "my-project"
+		return p
+	}
+	sentryRegexp := func(addr string) Provider {
+		p := sentry(addr).(*sentryProvider)
+		p.message = "error ("
+		p.regexp = true
+		return p
+	}
+
+	for _, test := range []struct {
+		name               string
+		provider           func(addr string) Provider
+		status             int
+		body               string
+		expectedCount      int64
+		expectedErr        bool
+		expectedQueryError bool
+	}{
+		{
+			name:          "it should return the number of series from prometheus",
+			provider:      prometheus,
+			status:        http.StatusOK,
+			body:          `{"status":"success","data":{"resultType":"vector","result":[{"value":[1,"1"]}]}}`,
+			expectedCount: 1,
+		},
+		{
+			name:               "it should return a query error on a prometheus syntax error",
+			provider:           prometheus,
+			status:             http.StatusBadRequest,
+			body:               `{"status":"error","errorType":"bad_data","error":"parse error"}`,
+			expectedErr:        true,
+			expectedQueryError: true,
+		},
+		{
+			name:               "it should return a query error on an unexpected prometheus result type",
+			provider:           prometheus,
+			status:             http.StatusOK,
+			body:               `{"status":"success","data":{"resultType":"streams","result":[]}}`,
+			expectedErr:        true,
+			expectedQueryError: true,
+		},
+		{
+			name:        "it should return a datasource error on a prometheus timeout",
+			provider:    prometheus,
+			status:      http.StatusServiceUnavailable,
+			body:        `{"status":"error","errorType":"timeout","error":"query timed out"}`,
+			expectedErr: true,
+		},
+		{
+			name:        "it should return a datasource error when prometheus doesn't reply with json",
+			provider:    prometheus,
+			status:      http.StatusBadGateway,
+			body:        `<html>Bad Gateway</html>`,
+			expectedErr: true,
+		},
+		{
+			name:          "it should return the count from elasticsearch",
+			provider:      elasticsearch,
+			status:        http.StatusOK,
+			body:          `{"count":3}`,
+			expectedCount: 3,
+		},
+		{
+			name:               "it should return a query error on an elasticsearch parsing exception",
+			provider:           elasticsearch,
+			status:             http.StatusBadRequest,
+			body:               `{"error":{"type":"parsing_exception","reason":"unknown query"},"status":400}`,
+			expectedErr:        true,
+			expectedQueryError: true,
+		},
+		{
+			name:        "it should return a datasource error on an elasticsearch error document",
+			provider:    elasticsearch,
+			status:      http.StatusServiceUnavailable,
+			body:        `{"error":{"type":"cluster_block_exception","reason":"blocked"},"status":503}`,
+			expectedErr: true,
+		},
+		{
+			name:        "it should return a datasource error when the elasticsearch count is missing",
+			provider:    elasticsearch,
+			status:      http.StatusOK,
+			body:        `{}`,
+			expectedErr: true,
+		},
+		{
+			name:          "it should return the number of events from sentry",
+			provider:      sentry,
+			status:        http.StatusOK,
+			body:          `[{"message":"error"},{"message":"error"}]`,
+			expectedCount: 2,
+		},
+		{
+			name:               "it should return a query error on an unknown sentry project",
+			provider:           sentry,
+			status:             http.StatusNotFound,
+			body:               `{"detail":"The requested resource does not exist"}`,
+			expectedErr:        true,
+			expectedQueryError: true,
+		},
+		{
+			name:        "it should return a datasource error on a sentry authentication error",
+			provider:    sentry,
+			status:      http.StatusUnauthorized,
+			body:        `{"detail":"Invalid token"}`,
+			expectedErr: true,
+		},
+		{
+			name:               "it should return a query error on an invalid sentry regular expression",
+			provider:           sentryRegexp,
+			status:             http.StatusOK,
+			body:               `[{"message":"error"}]`,
+			expectedErr:        true,
+			expectedQueryError: true,
+		},
+		{
+			name:        "it should return a datasource error on a sentry error envelope",
+			provider:    sentry,
+			status:      http.StatusOK,
+			body:        `{"detail":"Internal error"}`,
+			expectedErr: true,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			server := newTestServer(test.status, test.body)
+			defer server.Close()
+
+			result, err := test.provider(server.URL).Query(context.Background())
+			if (err != nil) != test.expectedErr {
+				t.Fatalf("\ngiven %s\nexpected error: %v\ngot: %v\n", test.body, test.expectedErr, err)
+			}
+			if isQueryError(err) != test.expectedQueryError {
+				t.Errorf("\ngiven %s\nexpected query error: %v\ngot: %v\n", test.body, test.expectedQueryError, err)
+			}
+			if err == nil && result.Count != test.expectedCount {
+				t.Errorf("\ngiven %s\nexpected count: %d\ngot: %d\n", test.body, test.expectedCount, result.Count)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/releaseutil"
+)
+
+// errReleaseFailed is returned while waiting for a release which landed in
+// the FAILED status.
+var errReleaseFailed = errors.New("release failed")
+
+// releaseChangedError is returned when the release moved to another revision
+// than the pinned one, for example because of a concurrent upgrade.
+type releaseChangedError struct {
+	name     string
+	pinned   int32
+	revision int32
+}
+
+func (e *releaseChangedError) Error() string {
+	return fmt.Sprintf("release %s moved to revision %d while monitoring revision %d", e.name, e.revision, e.pinned)
+}
+
+func isReleaseChanged(err error) bool {
+	var changed *releaseChangedError
+	return errors.As(err, &changed)
+}
+
+// checkRevision returns a releaseChangedError if the last revision of the
+// release isn't the pinned one.
+func (e *engine) checkRevision() error {
+	res, err := e.client.ReleaseContent(e.name)
+	if err != nil {
+		return prettyError(err)
+	}
+
+	if revision := res.GetRelease().GetVersion(); revision != e.revision {
+		return &releaseChangedError{name: e.name, pinned: e.revision, revision: revision}
+	}
+
+	return nil
+}
+
+// workload is a resource of the release manifest whose readiness is checked
+// before monitoring.
+type workload struct {
+	Kind     string `yaml:"kind"`
+	Metadata struct {
+		Name      string `yaml:"name"`
+		Namespace string `yaml:"namespace"`
+	} `yaml:"metadata"`
+}
+
+func (w *workload) String() string {
+	return fmt.Sprintf("%s %s/%s", w.Kind, w.Metadata.Namespace, w.Metadata.Name)
+}
+
+// newKubeClient returns a Kubernetes client configured from the kubeconfig
+// file and the given context, the current one if empty.
+func newKubeClient(kubeContext string) (kubernetes.Interface, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("could not get the Kubernetes configuration: %s", err)
+	}
+
+	return kubernetes.NewForConfig(config)
+}
+
+// kubeClient returns the Kubernetes client of the engine, created on first
+// use as it is only needed by some of the features.
+func (e *engine) kubeClient() (kubernetes.Interface, error) {
+	e.kubeOnce.Do(func() {
+		if e.kube == nil {
+			e.kube, e.kubeErr = newKubeClient(e.kubeContext)
+		}
+	})
+	return e.kube, e.kubeErr
+}
+
+// waitForRelease polls the release until its status is DEPLOYED and all its
+// workloads are ready. It returns errReleaseFailed as soon as the release is
+// FAILED, or a releaseChangedError, other errors are considered as transient
+// and the release is polled again until the context is done.
+func (e *engine) waitForRelease(ctx context.Context) error {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		ready, err := e.releaseReady(ctx)
+		switch {
+		case err == errReleaseFailed, isReleaseChanged(err):
+			return err
+		case err != nil && ctx.Err() == nil:
+			fmt.Fprintf(e.out, "Could not check the readiness of %s: %s\n", e.name, err)
+		case ready:
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// releaseReady returns true if the release is deployed and its workloads are
+// ready, or only deployed if no Kubernetes client can be created.
+func (e *engine) releaseReady(ctx context.Context) (bool, error) {
+	var rel *release.Release
+	err := call(ctx, func() error {
+		res, err := e.client.ReleaseContent(e.name)
+		if err == nil {
+			rel = res.GetRelease()
+		}
+		return err
+	})
+	if err != nil {
+		return false, prettyError(err)
+	}
+
+	if revision := rel.GetVersion(); revision != e.revision {
+		return false, &releaseChangedError{name: e.name, pinned: e.revision, revision: revision}
+	}
+
+	switch code := rel.GetInfo().GetStatus().GetCode(); code {
+	case release.Status_DEPLOYED:
+	case release.Status_FAILED:
+		return false, errReleaseFailed
+	default:
+		debug("Release %s is %s", e.name, code)
+		return false, nil
+	}
+
+	workloads, err := parseWorkloads(rel.GetManifest(), rel.GetNamespace())
+	if err != nil {
+		return false, err
+	}
+	if len(workloads) == 0 {
+		return true, nil
+	}
+
+	// without access to the Kubernetes API, ie: when only Tiller can be
+	// reached, the release status is the only readiness check
+	kube, err := e.kubeClient()
+	if err != nil {
+		fmt.Fprintf(e.out, "Could not check the workloads of %s, only its status is checked: %s\n", e.name, err)
+		return true, nil
+	}
+
+	for _, w := range workloads {
+		ready, err := workloadReady(kube, w)
+		if err != nil {
+			return false, fmt.Errorf("%s: %s", w, err)
+		}
+		if !ready {
+			debug("Waiting for %s to be ready", w)
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// parseWorkloads returns the deployments, stateful sets and daemon sets of the
+// release manifest, in the release namespace unless specified.
+func parseWorkloads(manifest, namespace string) ([]*workload, error) {
+	workloads := []*workload{}
+	for _, doc := range releaseutil.SplitManifests(manifest) {
+		w := &workload{}
+		if err := yaml.Unmarshal([]byte(doc), w); err != nil {
+			return nil, fmt.Errorf("could not parse the release manifest: %s", err)
+		}
+
+		switch w.Kind {
+		case "Deployment", "StatefulSet", "DaemonSet":
+		default:
+			continue
+		}
+
+		if w.Metadata.Namespace == "" {
+			w.Metadata.Namespace = namespace
+		}
+		workloads = append(workloads, w)
+	}
+	return workloads, nil
+}
+
+// workloadReady returns true once the last generation of the workload is
+// rolled out and all its pods are ready.
+func workloadReady(kube kubernetes.Interface, w *workload) (bool, error) {
+	apps := kube.AppsV1()
+	name, namespace := w.Metadata.Name, w.Metadata.Namespace
+
+	switch w.Kind {
+	case "Deployment":
+		d, err := apps.Deployments(namespace).Get(name, v1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		replicas := int32(1)
+		if d.Spec.Replicas != nil {
+			replicas = *d.Spec.Replicas
+		}
+		return d.Status.ObservedGeneration >= d.Generation &&
+			d.Status.UpdatedReplicas == replicas &&
+			d.Status.ReadyReplicas >= replicas, nil
+
+	case "StatefulSet":
+		s, err := apps.StatefulSets(namespace).Get(name, v1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		replicas := int32(1)
+		if s.Spec.Replicas != nil {
+			replicas = *s.Spec.Replicas
+		}
+		return s.Status.ObservedGeneration >= s.Generation &&
+			s.Status.UpdatedReplicas == replicas &&
+			s.Status.ReadyReplicas >= replicas, nil
+
+	case "DaemonSet":
+		d, err := apps.DaemonSets(namespace).Get(name, v1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return d.Status.ObservedGeneration >= d.Generation &&
+			d.Status.UpdatedNumberScheduled == d.Status.DesiredNumberScheduled &&
+			d.Status.NumberReady >= d.Status.DesiredNumberScheduled, nil
+	}
+
+	return true, nil
+}
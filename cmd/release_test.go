@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestParseWorkloads(t *testing.T) {
+	manifest := `
+---
+# Source: app/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: app
+---
+# Source: app/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+---
+# Source: app/templates/statefulset.yaml
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+  namespace: storage
+`
+
+	workloads, err := parseWorkloads(manifest, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := []string{}
+	for _, w := range workloads {
+		output = append(output, w.String())
+	}
+
+	// the order of the documents of a manifest isn't preserved
+	expected := map[string]bool{"Deployment default/app": true, "StatefulSet storage/db": true}
+	if len(output) != len(expected) || !expected[output[0]] || !expected[output[1]] {
+		t.Errorf("\ngiven %s\nexpected: %v\ngot: %v\n", manifest, expected, output)
+	}
+}
+
+func TestWorkloadReady(t *testing.T) {
+	replicas := int32(3)
+
+	for _, test := range []struct {
+		name     string
+		status   appsv1.DeploymentStatus
+		expected bool
+	}{
+		{
+			name:     "it should be ready when all the replicas are updated and ready",
+			status:   appsv1.DeploymentStatus{ObservedGeneration: 2, UpdatedReplicas: 3, ReadyReplicas: 3},
+			expected: true,
+		},
+		{
+			name:     "it should not be ready while replicas are not updated",
+			status:   appsv1.DeploymentStatus{ObservedGeneration: 2, UpdatedReplicas: 2, ReadyReplicas: 3},
+			expected: false,
+		},
+		{
+			name:     "it should not be ready while replicas are not ready",
+			status:   appsv1.DeploymentStatus{ObservedGeneration: 2, UpdatedReplicas: 3, ReadyReplicas: 1},
+			expected: false,
+		},
+		{
+			name:     "it should not be ready before the last generation is observed",
+			status:   appsv1.DeploymentStatus{ObservedGeneration: 1, UpdatedReplicas: 3, ReadyReplicas: 3},
+			expected: false,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			kube := fake.NewSimpleClientset(&appsv1.Deployment{
+				ObjectMeta: v1.ObjectMeta{Name: "app", Namespace: "default", Generation: 2},
+				Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+				Status:     test.status,
+			})
+
+			w := &workload{Kind: "Deployment"}
+			w.Metadata.Name = "app"
+			w.Metadata.Namespace = "default"
+
+			output, err := workloadReady(kube, w)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(output, test.expected) {
+				t.Errorf("\ngiven %v\nexpected: %v\ngot: %v\n", spew.Sdump(test.status), test.expected, output)
+			}
+		})
+	}
+}
+
+func TestEngineWaitForReleaseTransientError(t *testing.T) {
+	client := newFakeHelmClient("my-release")
+	client.Rels[0].Manifest = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+`
+
+	e := newTestEngine(client, combinationRule{kind: ruleAny}, onDatasourceErrorAbort)
+	kube := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: v1.ObjectMeta{Name: "app", Namespace: client.Rels[0].Namespace},
+		Status:     appsv1.DeploymentStatus{UpdatedReplicas: 1, ReadyReplicas: 1},
+	})
+	e.kube = kube
+	e.revision = 2
+
+	// the first get of the deployment fails, the following ones go through
+	gets := 0
+	kube.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		if gets == 1 {
+			return true, nil, errors.New("the server is currently unable to handle the request")
+		}
+		return false, nil, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := e.waitForRelease(ctx); err != nil || gets != 2 {
+		t.Errorf("expected the release to be ready after 2 gets, got %d get(s), error %v", gets, err)
+	}
+}
+
+func TestEngineWaitForReleaseWithoutKubernetes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "helm-monitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kubeconfig := os.Getenv("KUBECONFIG")
+	defer os.Setenv("KUBECONFIG", kubeconfig)
+	os.Setenv("KUBECONFIG", filepath.Join(dir, "config"))
+
+	client := newFakeHelmClient("my-release")
+	client.Rels[0].Manifest = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+`
+
+	e := newTestEngine(client, combinationRule{kind: ruleAny}, onDatasourceErrorAbort)
+	e.kube = nil
+	e.kubeContext = "missing"
+	e.revision = 2
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := e.waitForRelease(ctx); err != nil {
+		t.Errorf("expected the deployed release to be ready without Kubernetes client, got %v", err)
+	}
+}
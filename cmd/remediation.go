@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/strvals"
+)
+
+// Actions applied to the release once a failure is detected.
+const (
+	remediationRollback  = "rollback"
+	remediationScaleDown = "scale-down"
+	remediationUpgrade   = "upgrade-with-values"
+	remediationWebhook   = "webhook"
+	remediationNotify    = "notify-only"
+)
+
+var remediationActions = []string{
+	remediationRollback,
+	remediationScaleDown,
+	remediationUpgrade,
+	remediationWebhook,
+	remediationNotify,
+}
+
+// webhookTimeout bounds the call to the webhook of the webhook remediation.
+const webhookTimeout = 30 * time.Second
+
+// remediation is an action applied to the release once a failure is detected.
+// Every action honors the dry-run option of the engine.
+type remediation interface {
+	// String returns the name of the action.
+	String() string
+
+	// progress describes the action while it is applied, ie: rolling back.
+	progress() string
+
+	// prepare checks that the action can be applied to the pinned revision
+	// and prints what is about to be done.
+	prepare(e *engine) error
+
+	// apply applies the action and returns a summary of what was done.
+	apply(e *engine) (string, error)
+}
+
+func validateRemediation(action string) error {
+	for _, a := range remediationActions {
+		if action == a {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown remediation %q, expected one of %s", action, strings.Join(remediationActions, ", "))
+}
+
+// parseRemediationValues parses the values set by the upgrade-with-values
+// remediation, using the format of helm upgrade --set.
+func parseRemediationValues(set []string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	for _, s := range set {
+		if err := strvals.ParseInto(s, values); err != nil {
+			return nil, fmt.Errorf("invalid value %q: %s", s, err)
+		}
+	}
+	return values, nil
+}
+
+// newRemediation returns the remediation configured on the engine, rollback
+// by default.
+func (e *engine) newRemediation() (remediation, error) {
+	switch e.remediationAction {
+	case "", remediationRollback:
+		return &rollbackRemediation{}, nil
+
+	case remediationScaleDown:
+		return &scaleDownRemediation{}, nil
+
+	case remediationUpgrade:
+		if len(e.remediationSet) == 0 {
+			return nil, fmt.Errorf("the %s remediation requires at least one value set with --remediation-set", remediationUpgrade)
+		}
+		values, err := parseRemediationValues(e.remediationSet)
+		if err != nil {
+			return nil, err
+		}
+		return &upgradeRemediation{values: values}, nil
+
+	case remediationWebhook:
+		if e.webhookURL == "" {
+			return nil, fmt.Errorf("the %s remediation requires --webhook-url", remediationWebhook)
+		}
+		u, err := url.Parse(e.webhookURL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook URL %q", e.webhookURL)
+		}
+		return &webhookRemediation{url: u}, nil
+
+	case remediationNotify:
+		return &notifyRemediation{}, nil
+	}
+
+	return nil, validateRemediation(e.remediationAction)
+}
+
+// remediate applies the remediation to the release, unless the release moved
+// to another revision than the pinned one. The remediation is waited for when
+// the session is interrupted, it is only abandoned on a second interruption,
+// reporting that the state of the release is unknown. The pinned revision is
+// marked as failed once the remediation is applied, unless on dry-run.
+func (e *engine) remediate(interrupted, abandoned <-chan struct{}) error {
+	if err := e.action.prepare(e); err != nil {
+		return err
+	}
+
+	if e.approval != "" && e.action.String() != remediationNotify {
+		if err := e.approve(interrupted); err != nil {
+			return err
+		}
+	}
+
+	type outcome struct {
+		summary string
+		err     error
+	}
+
+	done := make(chan outcome, 1)
+	go func() {
+		if err := e.checkRevision(); err != nil {
+			done <- outcome{err: err}
+			return
+		}
+
+		summary, err := e.action.apply(e)
+		done <- outcome{summary: summary, err: err}
+	}()
+
+	for {
+		select {
+		case o := <-done:
+			if isReleaseChanged(o.err) {
+				return e.releaseChanged(o.err)
+			}
+			if o.err != nil {
+				return o.err
+			}
+			fmt.Fprintf(e.out, "%s\n", o.summary)
+			e.markFailed()
+			return nil
+
+		case <-interrupted:
+			fmt.Fprintf(e.out, "Interrupted, waiting for the %s to complete, interrupt again to abandon it...\n", e.action)
+			interrupted = nil
+
+		case <-abandoned:
+			fmt.Fprintf(e.out, "Remediation %s abandoned, check the state of the release with: helm history %s\n", e.action, e.name)
+			return fmt.Errorf("%s of %s abandoned, the release may be left in an inconsistent state", e.action, e.name)
+		}
+	}
+}
+
+// rollbackRemediation rolls back the release to the revision chosen by
+// rollbackTarget, once allowed by the safeguards.
+type rollbackRemediation struct {
+	target int32
+}
+
+func (r *rollbackRemediation) String() string   { return remediationRollback }
+func (r *rollbackRemediation) progress() string { return "rolling back" }
+
+func (r *rollbackRemediation) prepare(e *engine) error {
+	target, reason, err := e.rollbackTarget()
+	if err != nil {
+		return err
+	}
+
+	if err := e.checkSafeguards(target); err != nil {
+		fmt.Fprintf(e.out, "%s, not rolling back\n", err)
+		return err
+	}
+
+	fmt.Fprintf(e.out, "Rolling back %s from revision %d to revision %d, %s\n", e.name, e.revision, target, reason)
+	r.target = target
+	return nil
+}
+
+func (r *rollbackRemediation) apply(e *engine) (string, error) {
+	_, err := e.client.RollbackRelease(
+		e.name,
+		helm.RollbackDryRun(e.dryRun),
+		helm.RollbackRecreate(false),
+		helm.RollbackForce(e.force),
+		helm.RollbackDisableHooks(e.disableHooks),
+		helm.RollbackVersion(r.target),
+		helm.RollbackTimeout(e.rollbackTimeout),
+		helm.RollbackWait(e.wait))
+	if err != nil {
+		return "", prettyError(err)
+	}
+
+	e.recordRollback(r.target)
+	return fmt.Sprintf("Successfully rolled back to revision %d!", r.target), nil
+}
+
+// scaleDownRemediation scales the deployments and stateful sets of the
+// release to zero replicas.
+type scaleDownRemediation struct {
+	workloads []*workload
+}
+
+func (r *scaleDownRemediation) String() string   { return remediationScaleDown }
+func (r *scaleDownRemediation) progress() string { return "scaling down" }
+
+func (r *scaleDownRemediation) prepare(e *engine) error {
+	res, err := e.client.ReleaseContent(e.name, helm.ContentReleaseVersion(e.revision))
+	if err != nil {
+		return prettyError(err)
+	}
+
+	workloads, err := parseWorkloads(res.GetRelease().GetManifest(), res.GetRelease().GetNamespace())
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for _, w := range workloads {
+		if w.Kind == "Deployment" || w.Kind == "StatefulSet" {
+			r.workloads = append(r.workloads, w)
+			names = append(names, w.String())
+		}
+	}
+	if len(r.workloads) == 0 {
+		return fmt.Errorf("release %s has no deployment or stateful set to scale down", e.name)
+	}
+
+	fmt.Fprintf(e.out, "Scaling down %s to 0 replicas: %s\n", e.name, strings.Join(names, ", "))
+	return nil
+}
+
+func (r *scaleDownRemediation) apply(e *engine) (string, error) {
+	if e.dryRun {
+		return fmt.Sprintf("Dry-run, %d workload(s) not scaled down", len(r.workloads)), nil
+	}
+
+	kube, err := e.kubeClient()
+	if err != nil {
+		return "", err
+	}
+
+	apps := kube.AppsV1()
+	zero := int32(0)
+	for _, w := range r.workloads {
+		name, namespace := w.Metadata.Name, w.Metadata.Namespace
+
+		switch w.Kind {
+		case "Deployment":
+			d, err := apps.Deployments(namespace).Get(name, v1.GetOptions{})
+			if err == nil {
+				d.Spec.Replicas = &zero
+				_, err = apps.Deployments(namespace).Update(d)
+			}
+			if err != nil {
+				return "", fmt.Errorf("could not scale down %s: %s", w, err)
+			}
+
+		case "StatefulSet":
+			s, err := apps.StatefulSets(namespace).Get(name, v1.GetOptions{})
+			if err == nil {
+				s.Spec.Replicas = &zero
+				_, err = apps.StatefulSets(namespace).Update(s)
+			}
+			if err != nil {
+				return "", fmt.Errorf("could not scale down %s: %s", w, err)
+			}
+		}
+	}
+
+	return fmt.Sprintf("Successfully scaled down %d workload(s)!", len(r.workloads)), nil
+}
+
+// upgradeRemediation upgrades the release with its current chart and values
+// overridden by the given ones, for example to switch it to a safe mode.
+type upgradeRemediation struct {
+	values map[string]interface{}
+	chart  *chart.Chart
+}
+
+func (r *upgradeRemediation) String() string   { return remediationUpgrade }
+func (r *upgradeRemediation) progress() string { return "upgrading with values" }
+
+func (r *upgradeRemediation) prepare(e *engine) error {
+	res, err := e.client.ReleaseContent(e.name, helm.ContentReleaseVersion(e.revision))
+	if err != nil {
+		return prettyError(err)
+	}
+	r.chart = res.GetRelease().GetChart()
+
+	fmt.Fprintf(e.out, "Upgrading %s from revision %d with values %s\n", e.name, e.revision, strings.Join(e.remediationSet, ","))
+	return nil
+}
+
+func (r *upgradeRemediation) apply(e *engine) (string, error) {
+	raw, err := yaml.Marshal(r.values)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := e.client.UpdateReleaseFromChart(
+		e.name,
+		r.chart,
+		helm.UpdateValueOverrides(raw),
+		helm.ReuseValues(true),
+		helm.UpgradeDryRun(e.dryRun),
+		helm.UpgradeRecreate(false),
+		helm.UpgradeForce(e.force),
+		helm.UpgradeDisableHooks(e.disableHooks),
+		helm.UpgradeTimeout(e.rollbackTimeout),
+		helm.UpgradeWait(e.wait))
+	if err != nil {
+		return "", prettyError(err)
+	}
+
+	return fmt.Sprintf("Successfully upgraded %s to revision %d!", e.name, res.GetRelease().GetVersion()), nil
+}
+
+// webhookRemediation posts the failure to a webhook, leaving the remediation
+// to the receiver.
+type webhookRemediation struct {
+	url     *url.URL
+	payload []byte
+}
+
+// webhookPayload is the JSON document posted to the webhook.
+type webhookPayload struct {
+	Release  string         `json:"release"`
+	Revision int32          `json:"revision"`
+	DryRun   bool           `json:"dryRun"`
+	Checks   []webhookCheck `json:"checks"`
+}
+
+type webhookCheck struct {
+	Name     string  `json:"name"`
+	Breached bool    `json:"breached"`
+	Value    float64 `json:"value"`
+}
+
+func (r *webhookRemediation) String() string   { return remediationWebhook }
+func (r *webhookRemediation) progress() string { return "calling the webhook" }
+
+func (r *webhookRemediation) prepare(e *engine) error {
+	payload := &webhookPayload{
+		Release:  e.name,
+		Revision: e.revision,
+		DryRun:   e.dryRun,
+		Checks:   []webhookCheck{},
+	}
+	for _, c := range e.checks {
+		wc := webhookCheck{Name: c.name}
+		if len(c.history) > 0 {
+			last := c.history[len(c.history)-1]
+			wc.Breached = last.breached
+			wc.Value = last.value
+		}
+		payload.Checks = append(payload.Checks, wc)
+	}
+
+	var err error
+	r.payload, err = json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	// the URL may embed credentials, only its host is printed
+	fmt.Fprintf(e.out, "Calling the webhook on %s for %s revision %d\n", r.url.Host, e.name, e.revision)
+	return nil
+}
+
+func (r *webhookRemediation) apply(e *engine) (string, error) {
+	if e.dryRun {
+		return fmt.Sprintf("Dry-run, webhook not called, payload: %s", r.payload), nil
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	res, err := client.Post(r.url.String(), "application/json", bytes.NewReader(r.payload))
+	if err != nil {
+		return "", fmt.Errorf("could not call the webhook on %s: %s", r.url.Host, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return "", fmt.Errorf("webhook on %s returned unexpected status %s", r.url.Host, res.Status)
+	}
+
+	return "Successfully called the webhook!", nil
+}
+
+// notifyRemediation only reports the failure, leaving the release untouched.
+type notifyRemediation struct{}
+
+func (r *notifyRemediation) String() string          { return remediationNotify }
+func (r *notifyRemediation) progress() string        { return "notifying only" }
+func (r *notifyRemediation) prepare(e *engine) error { return nil }
+
+func (r *notifyRemediation) apply(e *engine) (string, error) {
+	return fmt.Sprintf("Release %s left untouched, no remediation applied (%s)", e.name, remediationNotify), nil
+}
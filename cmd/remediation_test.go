@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEngineRemediation(t *testing.T) {
+	manifest := `
+---
+# Source: app/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+`
+
+	for _, test := range []struct {
+		name              string
+		action            string
+		set               []string
+		dryRun            bool
+		expectedRollbacks int
+		expectedRevision  int32
+		expectedReplicas  int32
+		expectedCalls     int
+		expectedFailed    int
+		expectedErr       bool
+	}{
+		{
+			name:              "it should rollback by default",
+			expectedRollbacks: 1,
+			expectedRevision:  2,
+			expectedReplicas:  3,
+			expectedFailed:    1,
+		},
+		{
+			name:              "it should not mark the revision as failed on dry-run",
+			dryRun:            true,
+			expectedRollbacks: 1,
+			expectedRevision:  2,
+			expectedReplicas:  3,
+		},
+		{
+			name:             "it should scale down the workloads of the release",
+			action:           remediationScaleDown,
+			expectedRevision: 2,
+			expectedReplicas: 0,
+			expectedFailed:   1,
+		},
+		{
+			name:             "it should not scale down the workloads on dry-run",
+			action:           remediationScaleDown,
+			dryRun:           true,
+			expectedRevision: 2,
+			expectedReplicas: 3,
+		},
+		{
+			name:             "it should upgrade the release with the given values",
+			action:           remediationUpgrade,
+			set:              []string{"safeMode=true"},
+			expectedRevision: 3,
+			expectedReplicas: 3,
+			expectedFailed:   1,
+		},
+		{
+			name:             "it should not upgrade the release on dry-run",
+			action:           remediationUpgrade,
+			set:              []string{"safeMode=true"},
+			dryRun:           true,
+			expectedRevision: 2,
+			expectedReplicas: 3,
+		},
+		{
+			name:             "it should fail to upgrade the release without values",
+			action:           remediationUpgrade,
+			expectedRevision: 2,
+			expectedReplicas: 3,
+			expectedErr:      true,
+		},
+		{
+			name:             "it should call the webhook",
+			action:           remediationWebhook,
+			expectedRevision: 2,
+			expectedReplicas: 3,
+			expectedCalls:    1,
+			expectedFailed:   1,
+		},
+		{
+			name:             "it should not call the webhook on dry-run",
+			action:           remediationWebhook,
+			dryRun:           true,
+			expectedRevision: 2,
+			expectedReplicas: 3,
+		},
+		{
+			name:             "it should leave the release untouched with notify-only",
+			action:           remediationNotify,
+			expectedRevision: 2,
+			expectedReplicas: 3,
+			expectedFailed:   1,
+		},
+		{
+			name:             "it should reject an unknown remediation",
+			action:           "delete",
+			expectedRevision: 2,
+			expectedReplicas: 3,
+			expectedErr:      true,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if !strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+					w.WriteHeader(http.StatusBadRequest)
+				}
+			}))
+			defer server.Close()
+
+			client := newFakeHelmClient("my-release")
+			client.Rels[0].Manifest = manifest
+
+			e := newTestEngine(client, combinationRule{kind: ruleAny}, onDatasourceErrorAbort,
+				&fakeProvider{results: []*Result{{Count: 2}}})
+			e.remediationAction = test.action
+			e.remediationSet = test.set
+			e.webhookURL = server.URL
+			e.dryRun = test.dryRun
+
+			replicas := int32(3)
+			_, err := e.kube.AppsV1().Deployments("default").Create(&appsv1.Deployment{
+				ObjectMeta: v1.ObjectMeta{Name: "app", Namespace: "default"},
+				Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = e.run(context.Background())
+
+			d, getErr := e.kube.AppsV1().Deployments("default").Get("app", v1.GetOptions{})
+			if getErr != nil {
+				t.Fatal(getErr)
+			}
+
+			failed, recordsErr := (&releaseRecords{kube: e.kube, namespace: e.tillerNamespace}).revisions(e.name, recordFailed)
+			if recordsErr != nil {
+				t.Fatal(recordsErr)
+			}
+
+			if client.rollbacks != test.expectedRollbacks ||
+				client.Rels[0].Version != test.expectedRevision ||
+				*d.Spec.Replicas != test.expectedReplicas ||
+				calls != test.expectedCalls ||
+				len(failed) != test.expectedFailed ||
+				(err != nil) != test.expectedErr {
+				t.Errorf(
+					"\nexpected: %d rollback(s), revision %d, %d replica(s), %d webhook call(s), %d failed revision(s), error %v\n"+
+						"got: %d rollback(s), revision %d, %d replica(s), %d webhook call(s), %d failed revision(s), error %v\n",
+					test.expectedRollbacks,
+					test.expectedRevision,
+					test.expectedReplicas,
+					test.expectedCalls,
+					test.expectedFailed,
+					test.expectedErr,
+					client.rollbacks,
+					client.Rels[0].Version,
+					*d.Spec.Replicas,
+					calls,
+					len(failed),
+					err,
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const (
+	onDatasourceErrorKeep     = "keep"
+	onDatasourceErrorAbort    = "abort"
+	onDatasourceErrorRollback = "rollback"
+)
+
+// maxRetryBackoff caps the exponential backoff between two attempts.
+const maxRetryBackoff = 30 * time.Second
+
+// retryPolicy retries a failed provider query with an exponential backoff and
+// jitter, as long as the context is not done.
+type retryPolicy struct {
+	retries int
+	backoff time.Duration
+}
+
+func (r retryPolicy) validate() error {
+	if r.retries < 0 {
+		return fmt.Errorf("retries must not be negative, got %d", r.retries)
+	}
+	if r.backoff < 0 {
+		return fmt.Errorf("retry backoff must not be negative, got %s", r.backoff)
+	}
+	return nil
+}
+
+// query runs the provider query, retrying on datasource errors. It returns the
+// number of failed attempts along with the result or the last error.
+func (r retryPolicy) query(ctx context.Context, provider Provider) (result *Result, failures int, err error) {
+	for attempt := 0; ; attempt++ {
+		result, err = provider.Query(ctx)
+		if err == nil {
+			return result, failures, nil
+		}
+
+		failures++
+
+		if attempt >= r.retries || isQueryError(err) {
+			return nil, failures, err
+		}
+
+		wait := r.wait(attempt)
+		debug("Query failed (attempt %d of %d), retrying in %s: %s", attempt+1, r.retries+1, wait, err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, failures, err
+		}
+	}
+}
+
+// wait returns the time to wait before the next attempt: the backoff doubled
+// at each attempt, capped, with a random jitter of up to half of its value.
+func (r retryPolicy) wait(attempt int) time.Duration {
+	backoff := r.backoff
+	for i := 0; i < attempt && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+
+	half := int64(backoff / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// validateOnDatasourceError checks the policy applied when the error budget
+// is exhausted.
+func validateOnDatasourceError(policy string) error {
+	switch policy {
+	case onDatasourceErrorKeep, onDatasourceErrorAbort, onDatasourceErrorRollback:
+		return nil
+	}
+	return fmt.Errorf("unknown datasource error policy %q, expected one of keep, abort, rollback", policy)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// flakyProvider fails the given number of times before returning a result.
+type flakyProvider struct {
+	failures int
+	calls    int
+}
+
+func (p *flakyProvider) Query(ctx context.Context) (*Result, error) {
+	p.calls++
+	if p.calls <= p.failures {
+		return nil, errors.New("connection refused")
+	}
+	return &Result{Count: 1}, nil
+}
+
+func TestRetryPolicyQuery(t *testing.T) {
+	for _, test := range []struct {
+		name             string
+		retries          int
+		failures         int
+		expectedFailures int
+		expectedErr      bool
+	}{
+		{
+			name:             "it should not retry a successful query",
+			retries:          2,
+			failures:         0,
+			expectedFailures: 0,
+		},
+		{
+			name:             "it should retry a failed query",
+			retries:          2,
+			failures:         2,
+			expectedFailures: 2,
+		},
+		{
+			name:             "it should return the error once the retries are exhausted",
+			retries:          2,
+			failures:         5,
+			expectedFailures: 3,
+			expectedErr:      true,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			r := retryPolicy{retries: test.retries, backoff: time.Millisecond}
+			_, failures, err := r.query(context.Background(), &flakyProvider{failures: test.failures})
+			if failures != test.expectedFailures || (err != nil) != test.expectedErr {
+				t.Errorf("\nexpected: %d failure(s), error %v\ngot: %d failure(s), error %v\n",
+					test.expectedFailures, test.expectedErr, failures, err)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyWait(t *testing.T) {
+	r := retryPolicy{backoff: 100 * time.Millisecond}
+	for attempt, max := range []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+	} {
+		wait := r.wait(attempt)
+		if wait < max/2 || wait > max {
+			t.Errorf("attempt %d: expected a wait between %s and %s, got %s", attempt, max/2, max, wait)
+		}
+	}
+
+	if wait := r.wait(100); wait > maxRetryBackoff {
+		t.Errorf("expected the wait to be capped to %s, got %s", maxRetryBackoff, wait)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	ruleAny    = "any"
+	ruleAll    = "all"
+	ruleQuorum = "quorum"
+)
+
+// combinationRule decides whether a release failed from the number of failed
+// checks: when any check failed, when all of them failed or when at least
+// quorum of them failed.
+type combinationRule struct {
+	kind   string
+	quorum int
+}
+
+// newCombinationRule returns the rule of the given kind, quorum is only used
+// by the quorum rule and must be between 1 and the number of checks.
+func newCombinationRule(kind string, quorum, checks int) (combinationRule, error) {
+	switch kind {
+	case "", ruleAny:
+		return combinationRule{kind: ruleAny}, nil
+	case ruleAll:
+		return combinationRule{kind: ruleAll}, nil
+	case ruleQuorum:
+		if quorum < 1 || quorum > checks {
+			return combinationRule{}, fmt.Errorf("quorum must be between 1 and the number of checks (%d), got %d", checks, quorum)
+		}
+		return combinationRule{kind: ruleQuorum, quorum: quorum}, nil
+	}
+
+	return combinationRule{}, fmt.Errorf("unknown rule %q, expected one of any, all, quorum", kind)
+}
+
+func (r combinationRule) failed(failures, total int) bool {
+	switch r.kind {
+	case ruleAll:
+		return total > 0 && failures == total
+	case ruleQuorum:
+		return failures >= r.quorum
+	}
+	return failures > 0
+}
+
+func (r combinationRule) String() string {
+	if r.kind == ruleQuorum {
+		return fmt.Sprintf("quorum of %d", r.quorum)
+	}
+	if r.kind == "" {
+		return ruleAny
+	}
+	return r.kind
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// rollbackBlockedError is returned when a safeguard refused a rollback.
+type rollbackBlockedError struct {
+	safeguard string
+	reason    string
+}
+
+func (e *rollbackBlockedError) Error() string {
+	return fmt.Sprintf("rollback blocked by the %s safeguard: %s", e.safeguard, e.reason)
+}
+
+func isRollbackBlocked(err error) bool {
+	var blocked *rollbackBlockedError
+	return errors.As(err, &blocked)
+}
+
+// checkSafeguards returns a rollbackBlockedError if rolling back to the
+// target revision is refused: too many automatic rollbacks happened recently,
+// the target revision failed a previous monitoring session or it uses another
+// major version of the chart. The safeguards relying on the records of the
+// previous sessions are skipped if the records can't be read, a rollback is
+// never prevented by an unavailable Kubernetes API.
+func (e *engine) checkSafeguards(target int32) error {
+	records, err := e.records()
+	if err == nil {
+		err = e.checkRecords(records, target)
+	}
+	if isRollbackBlocked(err) {
+		return err
+	}
+	if err != nil {
+		fmt.Fprintf(e.out, "Could not read the previous monitoring sessions, skipping their safeguards: %s\n", err)
+	}
+
+	if !e.allowMajorRollback {
+		return e.checkChartVersion(target)
+	}
+
+	return nil
+}
+
+func (e *engine) checkRecords(records *releaseRecords, target int32) error {
+	if e.maxRollbacks > 0 {
+		count, err := records.rollbacksSince(e.name, time.Now().Add(-e.rollbackWindow))
+		if err != nil {
+			return err
+		}
+		if count >= e.maxRollbacks {
+			return &rollbackBlockedError{
+				safeguard: "max rollbacks",
+				reason: fmt.Sprintf("%s was already rolled back %d time(s) within the last %s, the maximum is %d",
+					e.name, count, e.rollbackWindow, e.maxRollbacks),
+			}
+		}
+	}
+
+	failed, err := records.revisions(e.name, recordFailed)
+	if err != nil {
+		return err
+	}
+	for _, revision := range failed {
+		if revision == target {
+			return &rollbackBlockedError{
+				safeguard: "failed revision",
+				reason:    fmt.Sprintf("revision %d failed a previous monitoring session", target),
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkChartVersion refuses a rollback to a revision using another major
+// version of the chart than the pinned revision.
+func (e *engine) checkChartVersion(target int32) error {
+	res, err := e.client.ReleaseHistory(e.name, helm.WithMaxHistory(256))
+	if err != nil {
+		return prettyError(err)
+	}
+
+	var current, previous *release.Release
+	for _, rel := range res.GetReleases() {
+		switch rel.GetVersion() {
+		case e.revision:
+			current = rel
+		case target:
+			previous = rel
+		}
+	}
+	if current == nil || previous == nil {
+		return fmt.Errorf("revisions %d and %d not found in the history of %s", e.revision, target, e.name)
+	}
+
+	currentVersion := current.GetChart().GetMetadata().GetVersion()
+	previousVersion := previous.GetChart().GetMetadata().GetVersion()
+
+	currentSemver, err := semver.NewVersion(currentVersion)
+	if err != nil {
+		return fmt.Errorf("invalid chart version %q of revision %d: %s", currentVersion, e.revision, err)
+	}
+	previousSemver, err := semver.NewVersion(previousVersion)
+	if err != nil {
+		return fmt.Errorf("invalid chart version %q of revision %d: %s", previousVersion, target, err)
+	}
+
+	if currentSemver.Major() != previousSemver.Major() {
+		return &rollbackBlockedError{
+			safeguard: "chart major version",
+			reason: fmt.Sprintf("revision %d uses chart version %s and revision %d chart version %s, use --allow-major-rollback to rollback anyway",
+				e.revision, currentVersion, target, previousVersion),
+		}
+	}
+
+	return nil
+}
+
+// recordRollback records a successful automatic rollback, dry-runs excepted.
+func (e *engine) recordRollback(target int32) {
+	if e.dryRun {
+		return
+	}
+
+	records, err := e.records()
+	if err == nil {
+		err = records.addRollback(e.name, e.revision, target, time.Now(), e.rollbackWindow)
+	}
+	if err != nil {
+		fmt.Fprintf(e.out, "Could not record the rollback: %s\n", err)
+	}
+}
+
+// markFailed records the pinned revision as failed once remediated, dry-runs
+// excepted.
+func (e *engine) markFailed() {
+	if e.dryRun {
+		return
+	}
+
+	if err := e.markRevision(recordFailed); err != nil {
+		fmt.Fprintf(e.out, "Could not mark revision %d as failed: %s\n", e.revision, err)
+	}
+}
@@ -0,0 +1,986 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// monitorSpec is the declarative description of a monitoring session, loaded
+// from a YAML or JSON file by the run subcommand. Optional values are
+// pointers so that unset values fall back to the command line flags.
+type monitorSpec struct {
+	Release      string       `yaml:"release"`
+	Interval     *duration    `yaml:"interval"`
+	Timeout      *duration    `yaml:"timeout"`
+	ReadyTimeout *duration    `yaml:"readyTimeout"`
+	WarmUp       *duration    `yaml:"warmUp"`
+	Rule         string       `yaml:"rule"`
+	Quorum       int          `yaml:"quorum"`
+	Policy       policySpec   `yaml:"policy"`
+	Rollback     rollbackSpec `yaml:"rollback"`
+	Checks       []*checkSpec `yaml:"checks"`
+
+	Remediation      remediationSpec      `yaml:"remediation"`
+	Approval         approvalSpec         `yaml:"approval"`
+	Pause            pauseSpec            `yaml:"pause"`
+	Lock             lockSpec             `yaml:"lock"`
+	DatasourceErrors datasourceErrorsSpec `yaml:"datasourceErrors"`
+	Verification     verificationSpec     `yaml:"verification"`
+
+	file string
+	root *yaml.Node
+}
+
+// policySpec describes the failure policy applied to the checks, values of a
+// check policy take precedence over the ones of the spec policy.
+type policySpec struct {
+	ConsecutiveFailures *int      `yaml:"consecutiveFailures"`
+	WindowSize          *int      `yaml:"windowSize"`
+	WindowFailures      *int      `yaml:"windowFailures"`
+	FailureDuration     *duration `yaml:"failureDuration"`
+}
+
+// verificationSpec describes the verification following a rollback, the
+// checks of the spec are evaluated again unless it defines its own checks.
+type verificationSpec struct {
+	Duration *duration    `yaml:"duration"`
+	Checks   []*checkSpec `yaml:"checks"`
+}
+
+// datasourceErrorsSpec describes how the errors returned by the providers are
+// retried and tolerated.
+type datasourceErrorsSpec struct {
+	Retries      *int      `yaml:"retries"`
+	RetryBackoff *duration `yaml:"retryBackoff"`
+	Budget       *int      `yaml:"budget"`
+	Policy       string    `yaml:"policy"`
+}
+
+// remediationSpec describes the action applied once a failure is detected,
+// the values are set by the upgrade-with-values action.
+type remediationSpec struct {
+	Action     string   `yaml:"action"`
+	Set        []string `yaml:"set"`
+	WebhookURL string   `yaml:"webhookURL"`
+}
+
+// approvalSpec describes the human approval required before remediating, the
+// bearer token of the http mode is only read from the command line or the
+// environment.
+type approvalSpec struct {
+	Mode    string    `yaml:"mode"`
+	Timeout *duration `yaml:"timeout"`
+	Default string    `yaml:"default"`
+	Address string    `yaml:"address"`
+}
+
+// pauseSpec describes the Alertmanager whose silences pause the monitoring.
+type pauseSpec struct {
+	Alertmanager string `yaml:"alertmanager"`
+	Label        string `yaml:"label"`
+}
+
+// lockSpec describes the lock preventing concurrent monitors of the release.
+type lockSpec struct {
+	OnLocked string    `yaml:"onLocked"`
+	Duration *duration `yaml:"duration"`
+}
+
+type rollbackSpec struct {
+	DryRun          *bool     `yaml:"dryRun"`
+	NoHooks         *bool     `yaml:"noHooks"`
+	Force           *bool     `yaml:"force"`
+	Wait            *bool     `yaml:"wait"`
+	Timeout         *duration `yaml:"timeout"`
+	OnFailedRelease *bool     `yaml:"onFailedRelease"`
+	Revision        *int32    `yaml:"revision"`
+
+	MaxRollbacks       *int      `yaml:"maxRollbacks"`
+	MaxRollbacksWindow *duration `yaml:"maxRollbacksWindow"`
+	AllowMajorRollback *bool     `yaml:"allowMajorRollback"`
+}
+
+// specProviders are the providers of the checks of a spec.
+var specProviders = []string{"prometheus", "prometheus-alerts", "alertmanager", "elasticsearch", "sentry"}
+
+// checkSpec describes a query run against a provider. Fields which are
+// specific to a provider are rejected by the others, see providerFields.
+type checkSpec struct {
+	Name                string      `yaml:"name"`
+	Provider            string      `yaml:"provider"`
+	Address             string      `yaml:"address"`
+	Query               string      `yaml:"query"`
+	ExpectedResultCount *int64      `yaml:"expectedResultCount"`
+	Condition           string      `yaml:"condition"`
+	Absent              *bool       `yaml:"absent"`
+	AbsentFor           *duration   `yaml:"absentFor"`
+	Volume              *volumeSpec `yaml:"volume"`
+	Policy              policySpec  `yaml:"policy"`
+
+	// prometheus, prometheus-alerts and alertmanager
+	BasicAuth       *basicAuthSpec `yaml:"basicAuth"`
+	BearerToken     string         `yaml:"bearerToken"`
+	BearerTokenFile string         `yaml:"bearerTokenFile"`
+	TLS             *tlsSpec       `yaml:"tls"`
+	ProxyURL        string         `yaml:"proxyURL"`
+
+	// prometheus
+	Threshold string    `yaml:"threshold"`
+	Range     *duration `yaml:"range"`
+	For       *duration `yaml:"for"`
+	Step      *duration `yaml:"step"`
+
+	// prometheus-alerts and alertmanager
+	Matchers       []string `yaml:"matchers"`
+	Pending        bool     `yaml:"pending"`
+	IgnoreExisting bool     `yaml:"ignoreExisting"`
+
+	// sentry
+	APIKey       string   `yaml:"apiKey"`
+	Organization string   `yaml:"organization"`
+	Project      string   `yaml:"project"`
+	Message      string   `yaml:"message"`
+	Regexp       bool     `yaml:"regexp"`
+	Tags         []string `yaml:"tags"`
+}
+
+// basicAuthSpec describes the basic authentication of an HTTP provider.
+type basicAuthSpec struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// tlsSpec describes the TLS configuration of an HTTP provider.
+type tlsSpec struct {
+	CAFile             string `yaml:"caFile"`
+	CertFile           string `yaml:"certFile"`
+	KeyFile            string `yaml:"keyFile"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+// providerFields are the fields of a check supported by some providers only,
+// set is true if the field is given.
+var providerFields = []struct {
+	key       string
+	providers []string
+	set       func(c *checkSpec) bool
+}{
+	{"query", []string{"prometheus", "elasticsearch"}, func(c *checkSpec) bool { return c.Query != "" }},
+	{"basicAuth", []string{"prometheus", "prometheus-alerts", "alertmanager"}, func(c *checkSpec) bool { return c.BasicAuth != nil }},
+	{"bearerToken", []string{"prometheus", "prometheus-alerts", "alertmanager"}, func(c *checkSpec) bool { return c.BearerToken != "" }},
+	{"bearerTokenFile", []string{"prometheus", "prometheus-alerts", "alertmanager"}, func(c *checkSpec) bool { return c.BearerTokenFile != "" }},
+	{"tls", []string{"prometheus", "prometheus-alerts", "alertmanager"}, func(c *checkSpec) bool { return c.TLS != nil }},
+	{"proxyURL", []string{"prometheus", "prometheus-alerts", "alertmanager"}, func(c *checkSpec) bool { return c.ProxyURL != "" }},
+	{"threshold", []string{"prometheus"}, func(c *checkSpec) bool { return c.Threshold != "" }},
+	{"range", []string{"prometheus"}, func(c *checkSpec) bool { return c.Range != nil }},
+	{"for", []string{"prometheus"}, func(c *checkSpec) bool { return c.For != nil }},
+	{"step", []string{"prometheus"}, func(c *checkSpec) bool { return c.Step != nil }},
+	{"matchers", []string{"prometheus-alerts", "alertmanager"}, func(c *checkSpec) bool { return len(c.Matchers) > 0 }},
+	{"pending", []string{"prometheus-alerts"}, func(c *checkSpec) bool { return c.Pending }},
+	{"ignoreExisting", []string{"prometheus-alerts"}, func(c *checkSpec) bool { return c.IgnoreExisting }},
+	{"apiKey", []string{"sentry"}, func(c *checkSpec) bool { return c.APIKey != "" }},
+	{"organization", []string{"sentry"}, func(c *checkSpec) bool { return c.Organization != "" }},
+	{"project", []string{"sentry"}, func(c *checkSpec) bool { return c.Project != "" }},
+	{"message", []string{"sentry"}, func(c *checkSpec) bool { return c.Message != "" }},
+	{"regexp", []string{"sentry"}, func(c *checkSpec) bool { return c.Regexp }},
+	{"tags", []string{"sentry"}, func(c *checkSpec) bool { return len(c.Tags) > 0 }},
+}
+
+// volumeSpec describes the companion query of a check measuring the traffic
+// volume. The query is run against the same provider as the check, for Sentry
+// it is the message of the events to count.
+type volumeSpec struct {
+	Query   string `yaml:"query"`
+	Minimum int64  `yaml:"minimum"`
+}
+
+// duration is either a Go duration string (30s, 5m) or a number of seconds.
+type duration time.Duration
+
+func (d *duration) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		if seconds, err := strconv.ParseInt(value.Value, 10, 64); err == nil {
+			*d = duration(time.Duration(seconds) * time.Second)
+			return nil
+		}
+		if v, err := time.ParseDuration(value.Value); err == nil {
+			*d = duration(v)
+			return nil
+		}
+	}
+
+	return &yaml.TypeError{Errors: []string{
+		fmt.Sprintf("line %d: cannot unmarshal %q into a duration, expected a number of seconds or a duration like 30s or 5m", value.Line, value.Value),
+	}}
+}
+
+// specError lists the problems found in a spec file, each prefixed with its
+// location.
+type specError []string
+
+func (e specError) Error() string {
+	return strings.Join(e, "\n")
+}
+
+var yamlErrorLineRegexp = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// loadSpec reads the monitor spec from the given YAML or JSON file. The spec
+// must be validated once the command line overrides are applied.
+func loadSpec(file string) (*monitorSpec, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseSpec(file, data)
+}
+
+func parseSpec(file string, data []byte) (*monitorSpec, error) {
+	root := &yaml.Node{}
+	if err := yaml.Unmarshal(data, root); err != nil {
+		return nil, newYAMLSpecError(file, err)
+	}
+
+	spec := &monitorSpec{
+		file: file,
+		root: root,
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(spec); err != nil {
+		if err == io.EOF {
+			return nil, specError{fmt.Sprintf("%s: spec is empty", file)}
+		}
+		return nil, newYAMLSpecError(file, err)
+	}
+
+	return spec, nil
+}
+
+// newYAMLSpecError converts the errors returned by the YAML decoder, which are
+// prefixed by their line number, into errors prefixed with the file location.
+func newYAMLSpecError(file string, err error) error {
+	messages := []string{err.Error()}
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		messages = typeErr.Errors
+	}
+
+	errs := specError{}
+	for _, message := range messages {
+		if match := yamlErrorLineRegexp.FindStringSubmatch(message); match != nil {
+			errs = append(errs, fmt.Sprintf("%s:%s: %s", file, match[1], match[2]))
+		} else {
+			errs = append(errs, fmt.Sprintf("%s: %s", file, strings.TrimPrefix(message, "yaml: ")))
+		}
+	}
+
+	return errs
+}
+
+// validate checks the spec values and returns all the problems found, located
+// in the spec file.
+func (s *monitorSpec) validate() error {
+	v := &specValidator{file: s.file, root: s.root}
+
+	if s.Release == "" {
+		v.errorf(nil, "release is required")
+	}
+
+	if s.Interval != nil && *s.Interval <= 0 {
+		v.errorf([]interface{}{"interval"}, "must be greater than 0")
+	}
+
+	if s.Timeout != nil && *s.Timeout <= 0 {
+		v.errorf([]interface{}{"timeout"}, "must be greater than 0")
+	}
+
+	if s.ReadyTimeout != nil && *s.ReadyTimeout < 0 {
+		v.errorf([]interface{}{"readyTimeout"}, "must not be negative")
+	}
+
+	if s.WarmUp != nil && *s.WarmUp < 0 {
+		v.errorf([]interface{}{"warmUp"}, "must not be negative")
+	}
+
+	if s.Rollback.Revision != nil && *s.Rollback.Revision < 1 {
+		v.errorf([]interface{}{"rollback", "revision"}, "must be greater than 0")
+	}
+
+	if s.Rollback.MaxRollbacks != nil && *s.Rollback.MaxRollbacks < 0 {
+		v.errorf([]interface{}{"rollback", "maxRollbacks"}, "must not be negative")
+	}
+
+	if s.Rollback.MaxRollbacksWindow != nil && *s.Rollback.MaxRollbacksWindow <= 0 {
+		v.errorf([]interface{}{"rollback", "maxRollbacksWindow"}, "must be greater than 0")
+	}
+
+	if s.Rollback.Timeout != nil && *s.Rollback.Timeout < 0 {
+		v.errorf([]interface{}{"rollback", "timeout"}, "must not be negative")
+	}
+
+	if s.Remediation.Action != "" {
+		if err := validateRemediation(s.Remediation.Action); err != nil {
+			v.errorf([]interface{}{"remediation", "action"}, "%s", err)
+		}
+	}
+
+	switch s.Remediation.Action {
+	case remediationUpgrade:
+		if len(s.Remediation.Set) == 0 {
+			v.errorf([]interface{}{"remediation", "set"}, "at least one value is required by the %s action", remediationUpgrade)
+		}
+	case remediationWebhook:
+		if s.Remediation.WebhookURL == "" {
+			v.errorf([]interface{}{"remediation", "webhookURL"}, "webhookURL is required by the %s action", remediationWebhook)
+		}
+	}
+
+	for i, set := range s.Remediation.Set {
+		if _, err := parseRemediationValues([]string{set}); err != nil {
+			v.errorf([]interface{}{"remediation", "set", i}, "%s", err)
+		}
+	}
+
+	if s.Approval.Mode != "" {
+		if err := validateApproval(s.Approval.Mode, approvalDefaultAbort); err != nil {
+			v.errorf([]interface{}{"approval", "mode"}, "%s", err)
+		}
+	}
+
+	if s.Approval.Default != "" {
+		if err := validateApproval("", s.Approval.Default); err != nil {
+			v.errorf([]interface{}{"approval", "default"}, "%s", err)
+		}
+	}
+
+	if s.Approval.Timeout != nil && *s.Approval.Timeout <= 0 {
+		v.errorf([]interface{}{"approval", "timeout"}, "must be greater than 0")
+	}
+
+	if s.Lock.OnLocked != "" {
+		if err := validateOnLocked(s.Lock.OnLocked); err != nil {
+			v.errorf([]interface{}{"lock", "onLocked"}, "%s", err)
+		}
+	}
+
+	if s.Lock.Duration != nil && *s.Lock.Duration <= 0 {
+		v.errorf([]interface{}{"lock", "duration"}, "must be greater than 0")
+	}
+
+	if s.DatasourceErrors.Retries != nil && *s.DatasourceErrors.Retries < 0 {
+		v.errorf([]interface{}{"datasourceErrors", "retries"}, "must not be negative")
+	}
+
+	if s.DatasourceErrors.RetryBackoff != nil && *s.DatasourceErrors.RetryBackoff < 0 {
+		v.errorf([]interface{}{"datasourceErrors", "retryBackoff"}, "must not be negative")
+	}
+
+	if s.DatasourceErrors.Budget != nil && *s.DatasourceErrors.Budget < 0 {
+		v.errorf([]interface{}{"datasourceErrors", "budget"}, "must not be negative")
+	}
+
+	if s.DatasourceErrors.Policy != "" {
+		if err := validateOnDatasourceError(s.DatasourceErrors.Policy); err != nil {
+			v.errorf([]interface{}{"datasourceErrors", "policy"}, "%s", err)
+		}
+	}
+
+	if len(s.Checks) == 0 {
+		v.errorf([]interface{}{"checks"}, "at least one check is required")
+	}
+
+	if _, err := newCombinationRule(s.Rule, s.Quorum, len(s.Checks)); err != nil {
+		if s.Rule == ruleQuorum {
+			v.errorf([]interface{}{"quorum"}, "%s", err)
+		} else {
+			v.errorf([]interface{}{"rule"}, "%s", err)
+		}
+	}
+
+	s.validateChecks(v, []interface{}{"checks"}, s.Checks)
+
+	if s.Verification.Duration != nil && *s.Verification.Duration < 0 {
+		v.errorf([]interface{}{"verification", "duration"}, "must not be negative")
+	}
+
+	if len(s.Verification.Checks) > 0 {
+		if _, err := newCombinationRule(s.Rule, s.Quorum, len(s.Verification.Checks)); err != nil {
+			v.errorf([]interface{}{"verification", "checks"}, "%s", err)
+		}
+		s.validateChecks(v, []interface{}{"verification", "checks"}, s.Verification.Checks)
+	}
+
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+
+	return nil
+}
+
+// validateChecks validates the checks at the given path, with their policy
+// merged with the spec policy, and the uniqueness of their names.
+func (s *monitorSpec) validateChecks(v *specValidator, path []interface{}, checks []*checkSpec) {
+	at := func(keys ...interface{}) []interface{} {
+		return append(append([]interface{}{}, path...), keys...)
+	}
+
+	names := map[string]int{}
+	for i, c := range checks {
+		c.validate(v, at(i))
+
+		// the policy is validated once merged with the spec policy, the error
+		// is located at the check policy if it has one
+		policy := c.policy(s.Policy, failurePolicy{consecutiveFailures: 1}, nil)
+		if err := policy.validate(); err != nil {
+			v.errorf(at(i, "policy"), "%s", err)
+		}
+
+		name := c.name(i)
+		if j, ok := names[name]; ok {
+			v.errorf(at(i, "name"), "duplicate check name %q, already used by %s", name, formatNodePath(at(j)))
+		}
+		names[name] = i
+	}
+}
+
+func (c *checkSpec) validate(v *specValidator, path []interface{}) {
+	at := func(key string) []interface{} {
+		return append(append([]interface{}{}, path...), key)
+	}
+
+	if c.ExpectedResultCount != nil && *c.ExpectedResultCount < 0 {
+		v.errorf(at("expectedResultCount"), "must not be negative")
+	}
+
+	if c.AbsentFor != nil && *c.AbsentFor < 0 {
+		v.errorf(at("absentFor"), "must not be negative")
+	}
+
+	if c.Volume != nil {
+		if c.Volume.Query == "" {
+			v.errorf(append(at("volume"), "query"), "query is required to measure the volume")
+		}
+		if c.Volume.Minimum < 0 {
+			v.errorf(append(at("volume"), "minimum"), "must not be negative")
+		}
+	}
+
+	if c.Condition != "" {
+		if c.ExpectedResultCount != nil {
+			v.errorf(at("condition"), "condition and expectedResultCount are mutually exclusive")
+		}
+		if _, err := parseCondition(c.Condition); err != nil {
+			v.errorf(at("condition"), "%s", err)
+		}
+	}
+
+	if c.Threshold != "" {
+		if _, err := parseThreshold(c.Threshold); err != nil {
+			v.errorf(at("threshold"), "%s", err)
+		}
+	}
+
+	if c.Condition != "" && (c.Threshold != "" || c.Range != nil || c.For != nil) {
+		v.errorf(at("condition"), "condition can't be used with threshold, range or for, use expectedResultCount instead")
+	}
+
+	if c.Range != nil || c.For != nil || c.Step != nil {
+		key := "range"
+		if c.Range == nil {
+			key = "for"
+		}
+		if c.Range == nil && c.For == nil {
+			key = "step"
+		}
+		if err := c.applyRange(newPrometheusProvider()); err != nil {
+			v.errorf(at(key), "%s", err)
+		}
+	}
+
+	switch c.Provider {
+	case "prometheus", "prometheus-alerts", "alertmanager":
+		c.validateHTTP(v, path)
+	}
+
+	c.validateFields(v, path)
+
+	switch c.Provider {
+	case "prometheus", "elasticsearch":
+		if c.Query == "" {
+			v.errorf(at("query"), "query is required by the %s provider", c.Provider)
+		}
+	case "prometheus-alerts", "alertmanager":
+		if len(c.Matchers) == 0 {
+			v.errorf(at("matchers"), "at least one matcher is required by the %s provider", c.Provider)
+		}
+		for i, matcher := range c.Matchers {
+			if _, err := parseLabelMatcher(matcher); err != nil {
+				v.errorf(append(at("matchers"), i), "%s", err)
+			}
+		}
+		if c.Volume != nil {
+			v.errorf(at("volume"), "volume is not supported by the %s provider", c.Provider)
+		}
+	case "sentry":
+		if c.APIKey == "" {
+			v.errorf(at("apiKey"), "apiKey is required by the sentry provider")
+		}
+		if c.Organization == "" {
+			v.errorf(at("organization"), "organization is required by the sentry provider")
+		}
+		if c.Project == "" {
+			v.errorf(at("project"), "project is required by the sentry provider")
+		}
+		if c.Regexp {
+			if _, err := regexp.Compile(c.Message); err != nil {
+				v.errorf(at("message"), "invalid regular expression: %s", err)
+			}
+		}
+	case "":
+		v.errorf(at("provider"), "provider is required, one of %s", strings.Join(specProviders, ", "))
+	default:
+		v.errorf(at("provider"), "unknown provider %q, expected one of %s", c.Provider, strings.Join(specProviders, ", "))
+	}
+}
+
+// validateFields rejects the fields which are not supported by the provider
+// of the check.
+func (c *checkSpec) validateFields(v *specValidator, path []interface{}) {
+	known := false
+	for _, p := range specProviders {
+		known = known || p == c.Provider
+	}
+	if !known {
+		return
+	}
+
+	for _, f := range providerFields {
+		if !f.set(c) {
+			continue
+		}
+		supported := false
+		for _, p := range f.providers {
+			supported = supported || p == c.Provider
+		}
+		if !supported {
+			v.errorf(append(append([]interface{}{}, path...), f.key), "%s is not supported by the %s provider", f.key, c.Provider)
+		}
+	}
+}
+
+// httpOptionsFields are the fields of a check reported for the validation
+// errors of its HTTP options.
+var httpOptionsFields = map[error]string{
+	errBearerTokenConflict: "bearerTokenFile",
+	errAuthConflict:        "basicAuth",
+	errClientCertificate:   "tls",
+	errProxyURL:            "proxyURL",
+}
+
+// validateHTTP validates the authentication, TLS and proxy of the check, the
+// CA bundle and the client certificate are loaded.
+func (c *checkSpec) validateHTTP(v *specValidator, path []interface{}) {
+	at := func(key string) []interface{} {
+		return append(append([]interface{}{}, path...), key)
+	}
+
+	if c.BasicAuth != nil && c.BasicAuth.Username == "" {
+		v.errorf(append(at("basicAuth"), "username"), "username is required by the basic authentication")
+	}
+
+	o := c.httpOptions()
+	if err := o.validate(); err != nil {
+		v.errorf(at(httpOptionsFields[err]), "%s", err)
+		return
+	}
+	if c.TLS != nil {
+		if _, err := o.client(0); err != nil {
+			v.errorf(at("tls"), "%s", err)
+		}
+	}
+}
+
+// httpOptions returns the authentication, TLS and proxy of the check.
+func (c *checkSpec) httpOptions() *httpOptions {
+	o := &httpOptions{
+		bearerToken:     c.BearerToken,
+		bearerTokenFile: c.BearerTokenFile,
+		proxyURL:        c.ProxyURL,
+	}
+	if c.BasicAuth != nil {
+		o.basicAuthUser = c.BasicAuth.Username
+		o.basicAuthPassword = c.BasicAuth.Password
+	}
+	if c.TLS != nil {
+		o.caFile = c.TLS.CAFile
+		o.certFile = c.TLS.CertFile
+		o.keyFile = c.TLS.KeyFile
+		o.insecureSkipVerify = c.TLS.InsecureSkipVerify
+	}
+	return o
+}
+
+// httpClient returns the HTTP client of the check, the check must have been
+// validated.
+func (c *checkSpec) httpClient(timeout time.Duration) *http.Client {
+	client, err := c.httpOptions().client(timeout)
+	if err != nil {
+		return &http.Client{Timeout: timeout}
+	}
+	return client
+}
+
+// policy returns the failure policy of the check, merging the check policy,
+// the spec policy and the defaults. Flags which were set on the command line
+// take precedence.
+func (c *checkSpec) policy(specPolicy policySpec, defaults failurePolicy, flags *pflag.FlagSet) failurePolicy {
+	policy := defaults
+
+	changed := func(flag string) bool {
+		return flags != nil && flags.Changed(flag)
+	}
+
+	setInt := func(flag string, dst *int, values ...*int) {
+		for _, v := range values {
+			if v != nil && !changed(flag) {
+				*dst = *v
+				return
+			}
+		}
+	}
+
+	setInt("consecutive-failures", &policy.consecutiveFailures, c.Policy.ConsecutiveFailures, specPolicy.ConsecutiveFailures)
+	setInt("window-size", &policy.windowSize, c.Policy.WindowSize, specPolicy.WindowSize)
+	setInt("window-failures", &policy.windowFailures, c.Policy.WindowFailures, specPolicy.WindowFailures)
+
+	for _, v := range []*duration{c.Policy.FailureDuration, specPolicy.FailureDuration} {
+		if v != nil && !changed("failure-duration") {
+			policy.failureDuration = time.Duration(*v)
+			break
+		}
+	}
+
+	return policy
+}
+
+// name returns the name of the check, defaulting to the provider name and its
+// position in the spec.
+func (c *checkSpec) name(i int) string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return fmt.Sprintf("%s[%d]", c.Provider, i)
+}
+
+// provider returns the provider described by the check, the check must have
+// been validated.
+func (c *checkSpec) provider() Provider {
+	switch c.Provider {
+	case "prometheus":
+		p := newPrometheusProvider()
+		if c.Address != "" {
+			p.addr = c.Address
+		}
+		p.query = c.Query
+		if c.Threshold != "" {
+			p.threshold, _ = parseThreshold(c.Threshold)
+		}
+		c.applyRange(p)
+		p.httpClient = c.httpClient(5 * time.Second)
+		return p
+	case "prometheus-alerts":
+		p := newPrometheusAlertsProvider()
+		if c.Address != "" {
+			p.addr = c.Address
+		}
+		p.matchers, _ = parseLabelMatchers(c.Matchers)
+		p.pending = c.Pending
+		p.ignoreExisting = c.IgnoreExisting
+		p.httpClient = c.httpClient(5 * time.Second)
+		return p
+	case "alertmanager":
+		p := newAlertmanagerProvider()
+		if c.Address != "" {
+			p.addr = c.Address
+		}
+		p.matchers, _ = parseLabelMatchers(c.Matchers)
+		p.httpClient = c.httpClient(5 * time.Second)
+		return p
+	case "elasticsearch":
+		p := newElasticsearchProvider()
+		if c.Address != "" {
+			p.addr = c.Address
+		}
+		p.setQuery(c.Query)
+		return p
+	case "sentry":
+		p := newSentryProvider()
+		if c.Address != "" {
+			p.addr = c.Address
+		}
+		p.apiKey = c.APIKey
+		p.organization = c.Organization
+		p.project = c.Project
+		p.message = c.Message
+		p.regexp = c.Regexp
+		if c.Tags != nil {
+			p.tags = c.Tags
+		}
+		return p
+	}
+
+	return nil
+}
+
+// applyRange applies the range query settings to the Prometheus provider.
+func (c *checkSpec) applyRange(p *prometheusProvider) error {
+	if c.Range != nil {
+		p.rangeWindow = time.Duration(*c.Range)
+	}
+	if c.For != nil {
+		p.forDuration = time.Duration(*c.For)
+	}
+	if c.Step != nil {
+		p.step = time.Duration(*c.Step)
+	}
+	return p.validateRange()
+}
+
+// volumeProvider returns the provider measuring the volume of the check, nil
+// if the check doesn't have a volume query.
+func (c *checkSpec) volumeProvider() Provider {
+	if c.Volume == nil {
+		return nil
+	}
+
+	volume := *c
+	volume.Threshold = ""
+	volume.Range, volume.For = nil, nil
+	if c.Provider == "sentry" {
+		volume.Message = c.Volume.Query
+	} else {
+		volume.Query = c.Volume.Query
+	}
+
+	return volume.provider()
+}
+
+// configure applies the spec values and checks to the engine, unless they are
+// overridden by a command line flag. The spec must have been validated.
+func (s *monitorSpec) configure(e *engine, flags *pflag.FlagSet) error {
+	setDuration := func(flag string, dst *time.Duration, src *duration) {
+		if src != nil && !flags.Changed(flag) {
+			*dst = time.Duration(*src)
+		}
+	}
+
+	setBool := func(flag string, dst *bool, src *bool) {
+		if src != nil && !flags.Changed(flag) {
+			*dst = *src
+		}
+	}
+
+	setDuration("interval", &e.interval, s.Interval)
+	setDuration("timeout", &e.timeout, s.Timeout)
+	setDuration("ready-timeout", &e.readyTimeout, s.ReadyTimeout)
+	setDuration("warm-up", &e.warmUp, s.WarmUp)
+
+	setBool("dry-run", &e.dryRun, s.Rollback.DryRun)
+	setBool("no-hooks", &e.disableHooks, s.Rollback.NoHooks)
+	setBool("force", &e.force, s.Rollback.Force)
+	setBool("wait", &e.wait, s.Rollback.Wait)
+	setBool("rollback-on-failed-release", &e.rollbackOnFailed, s.Rollback.OnFailedRelease)
+	setBool("allow-major-rollback", &e.allowMajorRollback, s.Rollback.AllowMajorRollback)
+	setDuration("max-rollbacks-window", &e.rollbackWindow, s.Rollback.MaxRollbacksWindow)
+
+	if s.Rollback.MaxRollbacks != nil && !flags.Changed("max-rollbacks") {
+		e.maxRollbacks = *s.Rollback.MaxRollbacks
+	}
+
+	if s.Rollback.Revision != nil && !flags.Changed("rollback-to") {
+		e.rollbackTo = *s.Rollback.Revision
+	}
+
+	if s.Rollback.Timeout != nil && !flags.Changed("rollback-timeout") {
+		e.rollbackTimeout = int64(time.Duration(*s.Rollback.Timeout) / time.Second)
+	}
+
+	if s.Remediation.Action != "" && !flags.Changed("remediation") {
+		e.remediationAction = s.Remediation.Action
+	}
+	if s.Remediation.Set != nil && !flags.Changed("remediation-set") {
+		e.remediationSet = s.Remediation.Set
+	}
+	if s.Remediation.WebhookURL != "" && !flags.Changed("webhook-url") {
+		e.webhookURL = s.Remediation.WebhookURL
+	}
+
+	if s.Approval.Mode != "" && !flags.Changed("approval") {
+		e.approval = s.Approval.Mode
+	}
+	if s.Approval.Default != "" && !flags.Changed("approval-default") {
+		e.approvalDefault = s.Approval.Default
+	}
+	if s.Approval.Address != "" && !flags.Changed("approval-address") {
+		e.approvalAddress = s.Approval.Address
+	}
+	setDuration("approval-timeout", &e.approvalTimeout, s.Approval.Timeout)
+
+	if s.Pause.Alertmanager != "" && !flags.Changed("pause-alertmanager") {
+		e.pauseAlertmanager = s.Pause.Alertmanager
+	}
+	if s.Pause.Label != "" && !flags.Changed("pause-label") {
+		e.pauseLabel = s.Pause.Label
+	}
+
+	if s.Lock.OnLocked != "" && !flags.Changed("on-locked") {
+		e.onLocked = s.Lock.OnLocked
+	}
+	setDuration("lock-duration", &e.lockDuration, s.Lock.Duration)
+
+	e.rule, _ = newCombinationRule(s.Rule, s.Quorum, len(s.Checks))
+
+	if s.DatasourceErrors.Budget != nil && !flags.Changed("error-budget") {
+		e.errorBudget = *s.DatasourceErrors.Budget
+	}
+	if s.DatasourceErrors.Policy != "" && !flags.Changed("on-datasource-error") {
+		e.onDatasourceError = s.DatasourceErrors.Policy
+	}
+
+	checks, err := s.newChecks(s.Checks, flags)
+	if err != nil {
+		return err
+	}
+	e.checks = checks
+
+	setDuration("verify", &e.verifyDuration, s.Verification.Duration)
+
+	e.verifyChecks, err = s.newChecks(s.Verification.Checks, flags)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// newChecks returns the checks described by the check specs.
+func (s *monitorSpec) newChecks(specs []*checkSpec, flags *pflag.FlagSet) ([]*check, error) {
+	checks := []*check{}
+	for i, c := range specs {
+		check, err := newCheck(c.name(i), c.provider())
+		if err != nil {
+			return nil, err
+		}
+
+		if !flags.Changed("condition") && !flags.Changed("expected-result-count") {
+			if c.Condition != "" {
+				check.condition, _ = parseCondition(c.Condition)
+			} else if c.ExpectedResultCount != nil {
+				check.condition = newCountCondition(*c.ExpectedResultCount)
+			}
+		}
+
+		if c.Volume != nil {
+			check.volume = c.volumeProvider()
+			if !flags.Changed("min-volume") {
+				check.minVolume = c.Volume.Minimum
+			}
+		}
+
+		if c.Absent != nil && !flags.Changed("absent") {
+			check.absent = *c.Absent
+		}
+		if c.AbsentFor != nil && !flags.Changed("absent-for") {
+			check.absentFor = time.Duration(*c.AbsentFor)
+		}
+
+		if s.DatasourceErrors.Retries != nil && !flags.Changed("retries") {
+			check.retry.retries = *s.DatasourceErrors.Retries
+		}
+		if s.DatasourceErrors.RetryBackoff != nil && !flags.Changed("retry-backoff") {
+			check.retry.backoff = time.Duration(*s.DatasourceErrors.RetryBackoff)
+		}
+
+		check.policy = c.policy(s.Policy, check.policy, flags)
+		checks = append(checks, check)
+	}
+
+	return checks, nil
+}
+
+// specValidator collects the validation errors of a spec, located using the
+// YAML node tree of the file.
+type specValidator struct {
+	file string
+	root *yaml.Node
+	errs specError
+}
+
+// errorf records an error for the value at the given path, made of mapping
+// keys and sequence indexes. When the value is missing, the error is located
+// at its closest parent.
+func (v *specValidator) errorf(path []interface{}, format string, args ...interface{}) {
+	node := lookupNode(v.root, path)
+	message := fmt.Sprintf(format, args...)
+	if len(path) > 0 {
+		message = fmt.Sprintf("%s: %s", formatNodePath(path), message)
+	}
+	v.errs = append(v.errs, fmt.Sprintf("%s:%d:%d: %s", v.file, node.Line, node.Column, message))
+}
+
+func lookupNode(node *yaml.Node, path []interface{}) *yaml.Node {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	for _, key := range path {
+		var next *yaml.Node
+		switch k := key.(type) {
+		case string:
+			if node.Kind == yaml.MappingNode {
+				for i := 0; i+1 < len(node.Content); i += 2 {
+					if node.Content[i].Value == k {
+						next = node.Content[i+1]
+					}
+				}
+			}
+		case int:
+			if node.Kind == yaml.SequenceNode && k < len(node.Content) {
+				next = node.Content[k]
+			}
+		}
+		if next == nil {
+			return node
+		}
+		node = next
+	}
+
+	return node
+}
+
+func formatNodePath(path []interface{}) string {
+	out := ""
+	for _, key := range path {
+		switch k := key.(type) {
+		case string:
+			if out != "" {
+				out += "."
+			}
+			out += k
+		case int:
+			out += fmt.Sprintf("[%d]", k)
+		}
+	}
+	return out
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+func TestParseSpec(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "it should accept a valid spec",
+			input: `
+release: my-release
+interval: 5s
+timeout: 120
+checks:
+  - provider: prometheus
+    query: up == 0
+`,
+			expected: "",
+		},
+		{
+			name:     "it should accept a valid JSON spec",
+			input:    `{"release": "my-release", "checks": [{"provider": "elasticsearch", "query": "status:500"}]}`,
+			expected: "",
+		},
+		{
+			name: "it should locate unknown fields",
+			input: `
+release: my-release
+checks:
+  - provider: prometheus
+    query: up == 0
+    adress: http://prometheus:9090
+`,
+			expected: "monitor.yaml:6: field adress not found in type main.checkSpec",
+		},
+		{
+			name: "it should locate invalid durations",
+			input: `
+release: my-release
+interval: often
+`,
+			expected: `monitor.yaml:3: cannot unmarshal "often" into a duration, expected a number of seconds or a duration like 30s or 5m`,
+		},
+		{
+			name: "it should locate missing values at their parent",
+			input: `
+release: my-release
+checks:
+  - provider: prometheus
+`,
+			expected: "monitor.yaml:4:5: checks[0].query: query is required by the prometheus provider",
+		},
+		{
+			name: "it should validate the quorum against the number of checks",
+			input: `
+release: my-release
+rule: quorum
+quorum: 3
+checks:
+  - provider: prometheus
+    query: up == 0
+  - provider: elasticsearch
+    query: status:500
+`,
+			expected: "monitor.yaml:4:9: quorum: quorum must be between 1 and the number of checks (2), got 3",
+		},
+		{
+			name: "it should reject duplicate check names",
+			input: `
+release: my-release
+checks:
+  - name: errors
+    provider: prometheus
+    query: up == 0
+  - name: errors
+    provider: elasticsearch
+    query: status:500
+`,
+			expected: `monitor.yaml:7:11: checks[1].name: duplicate check name "errors", already used by checks[0]`,
+		},
+		{
+			name: "it should validate the verification checks",
+			input: `
+release: my-release
+checks:
+  - provider: prometheus
+    query: up == 0
+verification:
+  duration: 5m
+  checks:
+    - provider: elasticsearch
+`,
+			expected: "monitor.yaml:9:7: verification.checks[0].query: query is required by the elasticsearch provider",
+		},
+		{
+			name: "it should require the values of the upgrade-with-values remediation",
+			input: `
+release: my-release
+remediation:
+  action: upgrade-with-values
+checks:
+  - provider: prometheus
+    query: up == 0
+`,
+			expected: "monitor.yaml:4:3: remediation.set: at least one value is required by the upgrade-with-values action",
+		},
+		{
+			name: "it should locate invalid conditions",
+			input: `
+release: my-release
+checks:
+  - provider: prometheus
+    query: up
+    condition: "=< 1"
+`,
+			expected: `monitor.yaml:6:16: checks[0].condition: invalid condition "=< 1", expected an operator (>=, <=, ==, !=, >, <, in, not in) followed by a value`,
+		},
+		{
+			name: "it should reject relative thresholds",
+			input: `
+release: my-release
+checks:
+  - provider: prometheus
+    query: rate(errors[5m]) / rate(requests[5m])
+    threshold: "> 10%"
+`,
+			expected: `monitor.yaml:6:16: checks[0].threshold: invalid threshold "> 10%", relative thresholds are not supported`,
+		},
+		{
+			name: "it should require a range covering the for duration",
+			input: `
+release: my-release
+checks:
+  - provider: prometheus
+    query: rate(errors[5m]) / rate(requests[5m])
+    range: 3m
+    for: 3m
+`,
+			expected: `monitor.yaml:6:12: checks[0].range: range 3m0s is too short to evaluate for 3m0s with a step of 15s`,
+		},
+		{
+			name: "it should reject a bearer token given twice",
+			input: `
+release: my-release
+checks:
+  - provider: prometheus
+    query: up == 0
+    bearerToken: secret
+    bearerTokenFile: /var/run/secrets/token
+`,
+			expected: `monitor.yaml:7:22: checks[0].bearerTokenFile: bearer token and bearer token file are mutually exclusive`,
+		},
+		{
+			name: "it should require the key of the client certificate",
+			input: `
+release: my-release
+checks:
+  - provider: alertmanager
+    matchers: [severity=critical]
+    tls:
+      certFile: client.pem
+`,
+			expected: `monitor.yaml:7:7: checks[0].tls: client certificate and key must be given together`,
+		},
+		{
+			name: "it should reject an invalid proxy URL without printing it",
+			input: `
+release: my-release
+checks:
+  - provider: prometheus
+    query: up == 0
+    proxyURL: http://alice:secret@[proxy
+`,
+			expected: `monitor.yaml:6:15: checks[0].proxyURL: invalid proxy URL`,
+		},
+		{
+			name: "it should reject the fields not supported by the provider",
+			input: `
+release: my-release
+checks:
+  - provider: alertmanager
+    matchers: [severity=critical]
+    pending: true
+    threshold: "> 1"
+`,
+			expected: "monitor.yaml:7:16: checks[0].threshold: threshold is not supported by the alertmanager provider\n" +
+				"monitor.yaml:6:14: checks[0].pending: pending is not supported by the alertmanager provider",
+		},
+		{
+			name: "it should reject a condition on a threshold",
+			input: `
+release: my-release
+checks:
+  - provider: prometheus
+    query: http_requests_total
+    threshold: "> 1"
+    condition: "< 10"
+`,
+			expected: `monitor.yaml:7:16: checks[0].condition: condition can't be used with threshold, range or for, use expectedResultCount instead`,
+		},
+		{
+			name: "it should report every validation error",
+			input: `
+timeout: 0
+checks:
+  - provider: graphite
+`,
+			expected: "monitor.yaml:2:1: release is required\n" +
+				"monitor.yaml:2:10: timeout: must be greater than 0\n" +
+				`monitor.yaml:4:15: checks[0].provider: unknown provider "graphite", expected one of prometheus, prometheus-alerts, alertmanager, elasticsearch, sentry`,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			spec, err := parseSpec("monitor.yaml", []byte(test.input))
+			if err == nil {
+				err = spec.validate()
+			}
+
+			output := ""
+			if err != nil {
+				output = err.Error()
+			}
+
+			if output != test.expected {
+				t.Errorf("\ngiven: \n%v\nexpected: \n%v\ngot: \n%v", test.input, test.expected, output)
+			}
+		})
+	}
+}
+
+func TestSpecConfigure(t *testing.T) {
+	cmd := newMonitorCmd(nil)
+	if err := cmd.ParseFlags([]string{"--interval", "3", "--dry-run"}); err != nil {
+		t.Fatal(err)
+	}
+
+	spec, err := parseSpec("monitor.yaml", []byte(`
+release: my-release
+interval: 1m
+timeout: 10m
+warmUp: 30s
+rollback:
+  dryRun: false
+  wait: true
+checks:
+  - provider: prometheus
+    query: up == 0
+    expectedResultCount: 2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := newEngine(spec.Release, nil, nil)
+	if err := spec.configure(e, cmd.Flags()); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.interval != 3*time.Second {
+		t.Errorf("expected the --interval flag to override the spec, got %s", e.interval)
+	}
+	if e.timeout != 10*time.Minute {
+		t.Errorf("expected the spec timeout to be used, got %s", e.timeout)
+	}
+	if e.warmUp != 30*time.Second {
+		t.Errorf("expected the spec warm-up to be used, got %s", e.warmUp)
+	}
+	if !e.dryRun || !e.wait {
+		t.Errorf("expected dry-run from the flag and wait from the spec, got dry-run %v, wait %v", e.dryRun, e.wait)
+	}
+	if len(e.checks) != 1 || e.checks[0].condition.String() != "> 2" {
+		t.Errorf("expected a check using the spec expected result count, got %v", spew.Sdump(e.checks))
+	}
+}
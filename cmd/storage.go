@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Kinds of records kept about a release.
+const (
+	recordKnownGood = "known-good"
+	recordFailed    = "failed"
+	recordRollback  = "rollback"
+)
+
+// releaseRecords keeps track of the outcome of the monitoring sessions of a
+// release: the revisions which passed or failed a session and the automatic
+// rollbacks. They are stored in a ConfigMap next to the release storage, in
+// the Tiller namespace, as Tiller replaces the labels of the release storage
+// whenever a revision is updated.
+type releaseRecords struct {
+	kube      kubernetes.Interface
+	namespace string
+}
+
+// configMapName returns the name of the ConfigMap holding the records of the
+// release, one key per record made of its kind and identifier.
+func (s *releaseRecords) configMapName(release string) string {
+	return "helm-monitor." + release
+}
+
+// add adds a record of the given kind and removes the records for which
+// expired returns true.
+func (s *releaseRecords) add(release, kind, id, value string, expired func(kind, id, value string) bool) error {
+	configMaps := s.kube.CoreV1().ConfigMaps(s.namespace)
+	key := kind + "." + id
+
+	cm, err := configMaps.Get(s.configMapName(release), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   s.configMapName(release),
+				Labels: map[string]string{"NAME": release, "OWNER": "HELM_MONITOR"},
+			},
+			Data: map[string]string{key: value},
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	if expired != nil {
+		for k, v := range cm.Data {
+			parts := strings.SplitN(k, ".", 2)
+			if len(parts) == 2 && expired(parts[0], parts[1], v) {
+				delete(cm.Data, k)
+			}
+		}
+	}
+	cm.Data[key] = value
+
+	_, err = configMaps.Update(cm)
+	return err
+}
+
+// list returns the identifiers and values of the records of the given kind.
+func (s *releaseRecords) list(release, kind string) (map[string]string, error) {
+	cm, err := s.kube.CoreV1().ConfigMaps(s.namespace).Get(s.configMapName(release), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	records := map[string]string{}
+	for k, v := range cm.Data {
+		if strings.HasPrefix(k, kind+".") {
+			records[strings.TrimPrefix(k, kind+".")] = v
+		}
+	}
+	return records, nil
+}
+
+// markRevision records the revision of the release as known-good or failed.
+func (s *releaseRecords) markRevision(release, kind string, revision int32, at time.Time) error {
+	return s.add(release, kind, strconv.Itoa(int(revision)), at.Format(time.RFC3339), nil)
+}
+
+// revisions returns the revisions of the release recorded with the given kind,
+// most recent first.
+func (s *releaseRecords) revisions(release, kind string) ([]int32, error) {
+	records, err := s.list(release, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := []int32{}
+	for id := range records {
+		revision, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s revision %q in ConfigMap %s", kind, id, s.configMapName(release))
+		}
+		revisions = append(revisions, int32(revision))
+	}
+
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i] > revisions[j] })
+
+	return revisions, nil
+}
+
+// addRollback records an automatic rollback of the release, forgetting the
+// rollbacks older than window.
+func (s *releaseRecords) addRollback(release string, from, to int32, at time.Time, window time.Duration) error {
+	expired := func(kind, id, value string) bool {
+		if kind != recordRollback {
+			return false
+		}
+		t, err := strconv.ParseInt(id, 10, 64)
+		return err == nil && at.Sub(time.Unix(0, t)) > window
+	}
+	value := fmt.Sprintf("%d->%d", from, to)
+	return s.add(release, recordRollback, strconv.FormatInt(at.UnixNano(), 10), value, expired)
+}
+
+// rollbacksSince returns the number of automatic rollbacks of the release
+// since the given time.
+func (s *releaseRecords) rollbacksSince(release string, since time.Time) (int, error) {
+	records, err := s.list(release, recordRollback)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for id := range records {
+		t, err := strconv.ParseInt(id, 10, 64)
+		if err == nil && !time.Unix(0, t).Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
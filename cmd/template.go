@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// templatedProvider is implemented by the providers whose query, address or
+// tags may contain Go templates, rendered with the metadata of the monitored
+// release before monitoring.
+type templatedProvider interface {
+	render(data *templateData) error
+}
+
+// templateData is the data available to the templates, ie:
+// {{ .Release.Name }}, {{ .Chart.Version }} or {{ .Values.image.tag }}.
+type templateData struct {
+	Release struct {
+		Name             string
+		Namespace        string
+		Revision         int32
+		PreviousRevision int32
+		DeployedAt       string
+		DeployedAtUnix   int64
+	}
+	Chart struct {
+		Name       string
+		Version    string
+		AppVersion string
+	}
+
+	// Values are the values supplied by the user to the release.
+	Values map[string]interface{}
+}
+
+// newTemplateData returns the template data of the release.
+func newTemplateData(rel *release.Release) (*templateData, error) {
+	data := &templateData{Values: map[string]interface{}{}}
+
+	data.Release.Name = rel.GetName()
+	data.Release.Namespace = rel.GetNamespace()
+	data.Release.Revision = rel.GetVersion()
+	if rel.GetVersion() > 1 {
+		data.Release.PreviousRevision = rel.GetVersion() - 1
+	}
+	if deployed := rel.GetInfo().GetLastDeployed(); deployed != nil {
+		t := time.Unix(deployed.Seconds, int64(deployed.Nanos)).UTC()
+		data.Release.DeployedAt = t.Format(time.RFC3339)
+		data.Release.DeployedAtUnix = t.Unix()
+	}
+
+	metadata := rel.GetChart().GetMetadata()
+	data.Chart.Name = metadata.GetName()
+	data.Chart.Version = metadata.GetVersion()
+	data.Chart.AppVersion = metadata.GetAppVersion()
+
+	if raw := rel.GetConfig().GetRaw(); raw != "" {
+		if err := yaml.Unmarshal([]byte(raw), &data.Values); err != nil {
+			return nil, fmt.Errorf("could not parse the values of release %s: %s", rel.GetName(), err)
+		}
+	}
+
+	return data, nil
+}
+
+// templates keeps the raw text of the templated fields of a provider, so that
+// they can be rendered again with the metadata of another revision.
+type templates map[*string]string
+
+// render renders the field in place from its raw text, recorded at its first
+// render.
+func (t *templates) render(data *templateData, name string, value *string) error {
+	if *t == nil {
+		*t = templates{}
+	}
+	raw, ok := (*t)[value]
+	if !ok {
+		raw = *value
+		(*t)[value] = raw
+	}
+
+	rendered := raw
+	if err := renderTemplate(data, name, &rendered); err != nil {
+		return err
+	}
+	*value = rendered
+	return nil
+}
+
+// renderTemplate renders the value in place, unless it doesn't contain any
+// template action. Missing fields and keys are errors.
+func renderTemplate(data *templateData, name string, value *string) error {
+	if !strings.Contains(*value, "{{") {
+		return nil
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(*value)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return err
+	}
+
+	debug("Rendered %s: %s", name, redactURL(out.String()))
+	*value = out.String()
+	return nil
+}
+
+// render renders the templates of the checks and verification checks with the
+// metadata of the release. It is called again with the release rolled back to
+// before verifying it.
+func (e *engine) render(rel *release.Release) error {
+	data, err := newTemplateData(rel)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range append(append([]*check{}, e.checks...), e.verifyChecks...) {
+		for _, provider := range []Provider{c.provider, c.volume} {
+			if p, ok := provider.(templatedProvider); ok {
+				if err := p.render(data); err != nil {
+					return fmt.Errorf("check %s: %s", c.name, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+func TestEngineRender(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		query         string
+		expectedQuery string
+		expectedError string
+	}{
+		{
+			name:          "it should leave a query without template unchanged",
+			query:         `up{job="api"} == 0`,
+			expectedQuery: `up{job="api"} == 0`,
+		},
+		{
+			name:          "it should render the release metadata",
+			query:         `up{release="{{ .Release.Name }}",namespace="{{ .Release.Namespace }}",revision="{{ .Release.Revision }}",previous="{{ .Release.PreviousRevision }}"} == 0`,
+			expectedQuery: `up{release="my-release",namespace="default",revision="2",previous="1"} == 0`,
+		},
+		{
+			name:          "it should render the chart metadata and the values",
+			query:         `up{chart="{{ .Chart.Name }}-{{ .Chart.Version }}",name="{{ .Values.name }}"} == 0`,
+			expectedQuery: `up{chart="foo-0.1.0-beta.1",name="value"} == 0`,
+		},
+		{
+			name:          "it should fail on a missing value",
+			query:         `up{tag="{{ .Values.image.tag }}"} == 0`,
+			expectedError: `check prometheus: template: query:1:18: executing "query" at <.Values.image.tag>: map has no entry for key "image"`,
+		},
+		{
+			name:          "it should fail on an invalid template",
+			query:         `up{release="{{ .Release.Name }"} == 0`,
+			expectedError: `check prometheus: template: query:1: unexpected "}" in operand`,
+		},
+	} {
+		t.Run(fmt.Sprintf("%s", test.name), func(t *testing.T) {
+			client := newFakeHelmClient("my-release")
+			res, err := client.ReleaseContent("my-release")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			provider := newPrometheusProvider()
+			provider.query = test.query
+			e := newTestEngine(client, combinationRule{kind: ruleAny}, onDatasourceErrorAbort)
+			e.checks = []*check{{name: "prometheus", provider: provider}}
+
+			err = e.render(res.GetRelease())
+
+			errMessage := ""
+			if err != nil {
+				errMessage = err.Error()
+			}
+			if errMessage != test.expectedError || (err == nil && provider.query != test.expectedQuery) {
+				t.Errorf(
+					"\nexpected: %s, error %q\ngot: %s, error %q\n",
+					spew.Sdump(test.expectedQuery),
+					test.expectedError,
+					spew.Sdump(provider.query),
+					errMessage,
+				)
+			}
+		})
+	}
+
+	t.Run("it should not monitor when a template can't be rendered", func(t *testing.T) {
+		client := newFakeHelmClient("my-release")
+		provider := &fakeProvider{results: []*Result{{Count: 2}}}
+		e := newTestEngine(client, combinationRule{kind: ruleAny}, onDatasourceErrorAbort, provider)
+		e.verifyChecks = []*check{{name: "verify", provider: &sentryProvider{tags: []string{"release={{ .Release.Nope }}"}}}}
+
+		err := e.run(context.Background())
+		if err == nil || client.rollbacks != 0 || provider.calls != 0 {
+			t.Errorf("expected an error before monitoring, got %d rollback(s), %d query(ies), error %v", client.rollbacks, provider.calls, err)
+		}
+	})
+
+	t.Run("it should verify with the templates rendered for the revision rolled back to", func(t *testing.T) {
+		var mu sync.Mutex
+		queries := []string{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query := r.FormValue("query")
+			mu.Lock()
+			queries = append(queries, query)
+			mu.Unlock()
+
+			if strings.Contains(query, `revision="2"`) {
+				fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"value":[1,"1"]}]}}`)
+				return
+			}
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+		}))
+		defer server.Close()
+
+		client := newFakeHelmClient("my-release")
+		client.started = make(chan struct{})
+		client.complete = make(chan struct{})
+		go func() {
+			<-client.started
+			client.revisions = []int32{1}
+			close(client.complete)
+		}()
+
+		provider := newPrometheusProvider()
+		provider.addr = server.URL
+		provider.query = `errors{revision="{{ .Release.Revision }}"} > 0`
+		e := newTestEngine(client, combinationRule{kind: ruleAny}, onDatasourceErrorAbort)
+		e.checks = []*check{{
+			name:      "prometheus",
+			provider:  provider,
+			condition: newCountCondition(0),
+			policy:    failurePolicy{consecutiveFailures: 1},
+		}}
+		// the stub is queried within an interval
+		e.interval = 10 * time.Millisecond
+		e.timeout = 200 * time.Millisecond
+		e.verifyDuration = 50 * time.Millisecond
+
+		err := e.run(context.Background())
+
+		mu.Lock()
+		defer mu.Unlock()
+		last := ""
+		if len(queries) > 0 {
+			last = queries[len(queries)-1]
+		}
+		if err != nil || client.rollbacks != 1 || last != `errors{revision="1"} > 0` {
+			t.Errorf("expected the revision 1 to be verified after 1 rollback, got %d rollback(s), last query %q, error %v", client.rollbacks, last, err)
+		}
+	})
+}
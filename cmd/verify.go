@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+// notRecoveredError is returned when the system didn't recover after a
+// remediation.
+type notRecoveredError struct {
+	action string
+	reason string
+}
+
+func (e *notRecoveredError) Error() string {
+	return fmt.Sprintf("the system did not recover after the %s: %s", e.action, e.reason)
+}
+
+func isNotRecovered(err error) bool {
+	var notRecovered *notRecoveredError
+	return errors.As(err, &notRecovered)
+}
+
+// remediateAndVerify remediates the release and verifies that the system
+// recovered, if a verification duration is set. There is nothing to verify
+// when the release was left untouched.
+func (e *engine) remediateAndVerify(ctx context.Context, interrupted, abandoned <-chan struct{}) error {
+	if err := e.remediate(interrupted, abandoned); err != nil {
+		return err
+	}
+
+	if e.verifyDuration <= 0 || e.action.String() == remediationNotify {
+		return nil
+	}
+	if e.dryRun {
+		fmt.Fprintf(e.out, "Dry-run, skipping the verification of the %s\n", e.action)
+		return nil
+	}
+
+	return e.verify(ctx)
+}
+
+// verify evaluates the verification checks against the last revision of the
+// release, created by the remediation if any, during the verification
+// duration. The system recovered if the
+// combination of the checks is not failing at the end of the verification.
+func (e *engine) verify(parent context.Context) error {
+	var rel *release.Release
+	err := call(parent, func() error {
+		res, err := e.client.ReleaseContent(e.name)
+		if err == nil {
+			rel = res.GetRelease()
+		}
+		return err
+	})
+	if parent.Err() != nil {
+		fmt.Fprintf(e.out, "Verification interrupted\n")
+		return nil
+	}
+	if err != nil {
+		return &notRecoveredError{action: e.action.String(), reason: fmt.Sprintf("could not get the release: %s", prettyError(err))}
+	}
+	e.revision = rel.GetVersion()
+
+	// the checks are queried with the metadata of the revision rolled back to
+	if err := e.render(rel); err != nil {
+		return &notRecoveredError{action: e.action.String(), reason: err.Error()}
+	}
+
+	if e.readyTimeout > 0 {
+		ctx, cancel := context.WithTimeout(parent, e.readyTimeout)
+		err := e.waitForRelease(ctx)
+		cancel()
+
+		if parent.Err() != nil {
+			fmt.Fprintf(e.out, "Verification interrupted\n")
+			return nil
+		}
+		if err != nil {
+			return &notRecoveredError{action: e.action.String(), reason: fmt.Sprintf("revision %d not ready: %s", e.revision, err)}
+		}
+	}
+
+	checks := e.verifyChecks
+	if len(checks) == 0 {
+		checks = e.checks
+		for _, c := range checks {
+			c.reset()
+		}
+	}
+
+	fmt.Fprintf(e.out, "Verifying revision %d for %s...\n", e.revision, e.verifyDuration)
+
+	ctx, cancel := context.WithTimeout(parent, e.verifyDuration)
+	defer cancel()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	var last []*evaluation
+	for ctx.Err() == nil {
+		select {
+		case <-ticker.C:
+			evaluations := e.evaluate(ctx, checks)
+			if ctx.Err() == nil {
+				last = evaluations
+			}
+		case <-ctx.Done():
+		}
+	}
+
+	if parent.Err() != nil {
+		fmt.Fprintf(e.out, "Verification interrupted\n")
+		return nil
+	}
+
+	if last == nil {
+		return &notRecoveredError{action: e.action.String(), reason: "no evaluation completed during the verification"}
+	}
+
+	failures := 0
+	verdicts := 0
+	for _, ev := range last {
+		if ev.err != nil {
+			return &notRecoveredError{action: e.action.String(), reason: fmt.Sprintf("check %s: %s", ev.check.name, prettyError(ev.err))}
+		}
+		if !ev.insufficient {
+			verdicts++
+		}
+		if ev.breached {
+			failures++
+		}
+	}
+
+	if e.rule.failed(failures, verdicts) {
+		fmt.Fprintf(e.out, "System not recovered after the %s\n", e.action)
+		e.report(last)
+		return &notRecoveredError{action: e.action.String(), reason: fmt.Sprintf("%d of %d check(s) still failing", failures, len(last))}
+	}
+
+	fmt.Fprintf(e.out, "System recovered after the %s (revision %d)\n", e.action, e.revision)
+	return nil
+}